@@ -16,6 +16,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -29,23 +30,227 @@ type KoBuilderSpec struct {
 
 	// Registry is is the GCP registry used to pull built images
 	Registry string `json:"registry,omitempty"`
-	// ServiceAccount is the GCP service account having access to registry
+	// ServiceAccount is the GCP service account having access to registry, for gcp registry credentials
 	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// RegistryCredentials references the secret containing the credentials to push images to the registry.
+	// By default, the key.json of the GCP service account is read from the secret named "gcloud"
+	RegistryCredentials *RegistryCredentials `json:"registryCredentials,omitempty"`
 	// Repository is the GitHub repository where the Go sources reside
 	Repository string `json:"repository,omitempty"`
+	// GitAuth references the secret containing the credentials to access a private repository
+	GitAuth *GitAuth `json:"gitAuth,omitempty"`
 	// Checkout is the branch / commit / tag of the repository to checkout
 	Checkout string `json:"checkout,omitempty"`
+	// Track defines a branch whose head is deployed each time it changes, instead of Checkout
+	Track *TrackSpec `json:"track,omitempty"`
 	// ConfigPath is the path in the repository containing the manifests to create Kubernetes resources
 	ConfigPath string `json:"configPath,omitempty"`
+	// RevisionHistoryLimit is the number of revisions to keep in the history (10 by default),
+	// at least the last one being kept
+	// +kubebuilder:validation:Minimum=1
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RunHistoryLimit is the number of runs whose Job and ConfigMap are kept (3 by default)
+	// +kubebuilder:validation:Minimum=1
+	RunHistoryLimit *int32 `json:"runHistoryLimit,omitempty"`
+	// RollbackTo is the number of a previous successful revision to deploy instead of Checkout.
+	// Remove it to deploy Checkout again
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+	// AutoRollback deploys again the last successful revision when the build of the spec fails,
+	// after its retries, or its workloads are unhealthy, until the spec or the head of the tracked branch changes
+	AutoRollback bool `json:"autoRollback,omitempty"`
+	// RetryPolicy defines how failed builds are retried. Failed builds are not retried if not specified
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// SupersedePolicy defines what is done with a running build when the spec changes:
+	// Cancel (the default) cancels it and starts a new build, Queue starts a new build when it terminates,
+	// Ignore lets it terminate without starting a new build
+	SupersedePolicy SupersedePolicy `json:"supersedePolicy,omitempty"`
+	// BackoffLimit is the number of retries of the pod of a build before the build is considered failed,
+	// 6 by default
+	// +kubebuilder:validation:Minimum=0
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+	// Timeout is the maximum duration of a build, after which its job is terminated. Builds have no timeout if not specified
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// PendingTimeout is the maximum duration the pod of a build can stay pending before the build is considered stuck, 10m by default
+	PendingTimeout *metav1.Duration `json:"pendingTimeout,omitempty"`
+	// Logs defines how the logs of the builds are kept
+	Logs *BuildLogsSpec `json:"logs,omitempty"`
+	// ApplyMode defines who applies the manifests of the repository: Builder (the default), the ko-builder job,
+	// or Operator, the job only building and pushing the images and reporting the resolved manifests,
+	// applied by the operator with server-side apply
+	ApplyMode ApplyMode `json:"applyMode,omitempty"`
+	// DriftPolicy defines what is done when the applied objects drift from the last applied manifests,
+	// in Operator apply mode: Ignore (the default) does not detect drifts, Report sets the Drifted condition,
+	// Reapply applies the manifests again
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// HealthCheck defines how the applied workloads are checked after a successful build
+	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
+	// Prune deletes the objects applied by a previous run which are no more in the manifests,
+	// unless annotated with ko.feloy.dev/prune=disabled. Only supported in Operator apply mode
+	Prune bool `json:"prune,omitempty"`
+	// Suspend prevents new builds from being started. A running build is handled as for any spec change,
+	// depending on SupersedePolicy
+	Suspend bool `json:"suspend,omitempty"`
+	// Builder contains the settings of the ko-builder pod
+	Builder *BuilderSpec `json:"builder,omitempty"`
+}
+
+// RegistryCredentialsType is the type of the registry credentials
+type RegistryCredentialsType string
+
+const (
+	// GCPCredentials are read from the key.json key of the secret, containing the key of a GCP service account
+	GCPCredentials RegistryCredentialsType = "gcp"
+	// DockerConfigCredentials are read from a kubernetes.io/dockerconfigjson secret
+	DockerConfigCredentials RegistryCredentialsType = "dockerconfig"
+	// BasicCredentials are read from the username and password keys of the secret
+	BasicCredentials RegistryCredentialsType = "basic"
+)
+
+// RegistryCredentials references the secret containing the credentials to push images to the registry
+type RegistryCredentials struct {
+	// Type is the type of the credentials, one of gcp, dockerconfig or basic
+	// +kubebuilder:validation:Enum=gcp;dockerconfig;basic
+	Type RegistryCredentialsType `json:"type"`
+	// SecretName is the name of the secret, in the namespace of the KoBuilder, containing the credentials
+	SecretName string `json:"secretName"`
+}
+
+// TrackSpec defines the branch of the repository to track
+type TrackSpec struct {
+	// Branch is the branch to track
+	Branch string `json:"branch"`
+	// Interval is the interval between two resolutions of the head of the branch, 5m by default
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// WebhookSecretName is the name of the secret whose "secret" key contains the secret
+	// of the push webhooks sent by the git server. Push webhooks are ignored if not specified
+	WebhookSecretName string `json:"webhookSecretName,omitempty"`
+}
+
+// SupersedePolicy defines what is done with a running build when the spec of the KoBuilder changes
+// +kubebuilder:validation:Enum=Cancel;Queue;Ignore
+type SupersedePolicy string
+
+const (
+	// CancelSupersededBuild cancels the running build and starts a new build for the current spec
+	CancelSupersededBuild SupersedePolicy = "Cancel"
+	// QueueSupersedingBuild starts a new build for the current spec when the running build terminates
+	QueueSupersedingBuild SupersedePolicy = "Queue"
+	// IgnoreSpecChange lets the running build terminate without starting a new build
+	IgnoreSpecChange SupersedePolicy = "Ignore"
+)
+
+// DriftPolicy defines what is done when the applied objects drift from the last applied manifests
+// +kubebuilder:validation:Enum=Ignore;Report;Reapply
+type DriftPolicy string
+
+const (
+	// IgnoreDrift does not detect drifts
+	IgnoreDrift DriftPolicy = "Ignore"
+	// ReportDrift sets the Drifted condition when applied objects drift
+	ReportDrift DriftPolicy = "Report"
+	// ReapplyDrift applies the manifests again when applied objects drift
+	ReapplyDrift DriftPolicy = "Reapply"
+)
+
+// HealthCheckSpec defines how the applied workloads are checked after a successful build:
+// the Deployments, StatefulSets, DaemonSets and Services owned by the KoBuilder must be available
+type HealthCheckSpec struct {
+	// Timeout is the maximum duration to wait for the workloads to be available after the build,
+	// after which the KoBuilder is Unhealthy, 5m by default. 0 disables the health check
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// DefaultBuilderImage is the ko-builder image used when not specified.
+// It does not report the resolved manifests, required by the Operator apply mode
+const DefaultBuilderImage = "feloy/ko-builder:release-1.4.0"
+
+// ApplyMode defines who applies the manifests of the repository
+// +kubebuilder:validation:Enum=Builder;Operator
+type ApplyMode string
+
+const (
+	// BuilderApply lets the ko-builder job apply the manifests
+	BuilderApply ApplyMode = "Builder"
+	// OperatorApply lets the operator apply the manifests resolved by the ko-builder job
+	OperatorApply ApplyMode = "Operator"
+)
+
+// RetryPolicy defines how failed builds are retried, with an exponential backoff
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries of a failed build
+	// +kubebuilder:validation:Minimum=0
+	MaxRetries int32 `json:"maxRetries"`
+	// InitialBackoff is the delay before the first retry, doubled for each following retry, 30s by default
+	InitialBackoff *metav1.Duration `json:"initialBackoff,omitempty"`
+	// MaxBackoff is the maximum delay between two retries, 10m by default
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+}
+
+// BuildLogsSpec defines how the logs of the builds are kept after the deletion of their job
+type BuildLogsSpec struct {
+	// TailLines is the number of lines kept from the end of the log of each container of a build, 200 by default
+	// +kubebuilder:validation:Minimum=1
+	TailLines *int64 `json:"tailLines,omitempty"`
+	// HistoryLimit is the number of builds whose logs are kept, 3 by default
+	// +kubebuilder:validation:Minimum=0
+	HistoryLimit *int32 `json:"historyLimit,omitempty"`
+	// FailedJobTTL is the duration a failed job and its pods are kept before being deleted.
+	// Failed jobs are kept with the other runs, up to the run history limit, if not specified
+	FailedJobTTL *metav1.Duration `json:"failedJobTTL,omitempty"`
+}
+
+// GitAuthType is the type of the git credentials
+type GitAuthType string
+
+const (
+	// SSHGitAuth credentials are read from the ssh-privatekey and known_hosts keys of the secret
+	SSHGitAuth GitAuthType = "ssh"
+	// TokenGitAuth credentials are read from the token key of the secret
+	TokenGitAuth GitAuthType = "token"
+)
+
+// GitAuth references the secret containing the credentials to access a private repository
+type GitAuth struct {
+	// Type is the type of the credentials, one of ssh or token
+	// +kubebuilder:validation:Enum=ssh;token
+	Type GitAuthType `json:"type"`
+	// SecretName is the name of the secret, in the namespace of the KoBuilder, containing the credentials
+	SecretName string `json:"secretName"`
+}
+
+// BuilderSpec defines the settings of the ko-builder pod
+type BuilderSpec struct {
+	// Image is the ko-builder image, the image configured for the operator by default
+	Image string `json:"image,omitempty"`
+	// ImagePullPolicy is the pull policy of the ko-builder image
+	// +kubebuilder:validation:Enum=Always;Never;IfNotPresent
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// ImagePullSecrets are references to secrets used to pull the ko-builder image
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Resources are the compute resources required by the ko-builder container
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// NodeSelector is the selector which must match a node's labels for the ko-builder pod to be scheduled on that node
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations are the tolerations of the ko-builder pod
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Affinity contains the scheduling constraints of the ko-builder pod
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// SecurityContext holds the pod-level security attributes of the ko-builder pod
+	SecurityContext *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+	// PriorityClassName is the priority class of the ko-builder pod
+	PriorityClassName string `json:"priorityClassName,omitempty"`
 }
 
 // KoBuilderState is the state of the KoBuilder
 type KoBuilderState string
 
 const (
-	// Deploying state when the job has been created and is not yet completed
+	// Pending state when the job has been created and its pods have not started yet
+	Pending KoBuilderState = "Pending"
+	// Deploying state when a pod of the job has started and the job is not yet completed,
+	// or when the job has completed and the applied workloads are not available yet
 	Deploying KoBuilderState = "Deploying"
-	// Deployed state when the job has completed
+	// Deployed state when the job has completed and the applied workloads are available
 	Deployed KoBuilderState = "Deployed"
 	// ErrorDeploying state when the job has failed
 	ErrorDeploying KoBuilderState = "ErrorDeploying"
@@ -53,15 +258,150 @@ const (
 	Unknown KoBuilderState = "Unknown"
 	// Updated state when the config has just been updated
 	Updated KoBuilderState = "Updated"
+	// Suspended state when the KoBuilder is suspended and no job is running
+	Suspended KoBuilderState = "Suspended"
+	// Unhealthy state when the job has completed but the applied workloads are not available
+	Unhealthy KoBuilderState = "Unhealthy"
+)
+
+// KoBuilderConditionType is the type of a KoBuilder condition
+type KoBuilderConditionType string
+
+const (
+	// ReadyCondition is true when the configuration has been accepted and the last build has been deployed
+	ReadyCondition KoBuilderConditionType = "Ready"
+	// BuildingCondition is true while the ko-builder job is running
+	BuildingCondition KoBuilderConditionType = "Building"
+	// DeployedCondition is true when the images have been pushed and the manifests applied
+	DeployedCondition KoBuilderConditionType = "Deployed"
+	// DegradedCondition is true when the last build has failed
+	DegradedCondition KoBuilderConditionType = "Degraded"
+	// SuspendedCondition is true when the KoBuilder is suspended, only set once it has been suspended
+	SuspendedCondition KoBuilderConditionType = "Suspended"
+	// DriftedCondition is true when applied objects have drifted from the last applied manifests,
+	// only set when drifts are detected
+	DriftedCondition KoBuilderConditionType = "Drifted"
+)
+
+// KoBuilderCondition describes the state of a KoBuilder at a certain point
+type KoBuilderCondition struct {
+	// Type of the condition, one of Ready, Building, Deployed, Degraded, Suspended or Drifted
+	Type KoBuilderConditionType `json:"type"`
+	// Status of the condition, one of True, False or Unknown
+	Status corev1.ConditionStatus `json:"status"`
+	// Reason is a CamelCase reason for the last transition of the condition
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable message indicating details about the transition
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is the last time the condition transitioned from one status to another
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// RevisionOutcome is the outcome of the run of a revision
+type RevisionOutcome string
+
+const (
+	// RevisionSucceeded outcome when the job of the revision has completed
+	RevisionSucceeded RevisionOutcome = "Succeeded"
+	// RevisionFailed outcome when the job of the revision has failed
+	RevisionFailed RevisionOutcome = "Failed"
 )
 
+// KoBuilderRevision is a run of the KoBuilder recorded in its history
+type KoBuilderRevision struct {
+	// Revision is the number of the revision, the number of its run
+	Revision int64 `json:"revision"`
+	// Checkout is the branch / commit / tag of the repository built by the run
+	Checkout string `json:"checkout,omitempty"`
+	// Commit is the commit SHA built by the run, reported by the builder or resolved from Checkout
+	// by the operator when the run is created
+	Commit string `json:"commit,omitempty"`
+	// Images are the references by digest of the images pushed by the run
+	Images []string `json:"images,omitempty"`
+	// Outcome is the outcome of the run, Succeeded or Failed
+	Outcome RevisionOutcome `json:"outcome"`
+	// Time is the time the run completed
+	Time metav1.Time `json:"time,omitempty"`
+	// Logs is the name of the ConfigMap containing the tail of the logs of the run, if kept
+	Logs string `json:"logs,omitempty"`
+	// RolledBack is true when the revision has failed and has been automatically rolled back
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// AutoRollbackStatus describes the automatic rollback to a previous successful revision
+type AutoRollbackStatus struct {
+	// From is the number of the failed revision
+	From int64 `json:"from"`
+	// To is the number of the successful revision deployed again
+	To int64 `json:"to"`
+	// Checkout is the checkout whose build has failed, the rollback lasting until the checkout to build changes
+	Checkout string `json:"checkout"`
+	// Generation is the generation of the KoBuilder whose build has failed, the rollback lasting until the spec changes
+	Generation int64 `json:"generation"`
+}
+
 // KoBuilderStatus defines the observed state of KoBuilder
 type KoBuilderStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// State indicates if the builder is "Deploying" or has "Deployed" the resources
+	// State indicates if the builder is "Deploying" or has "Deployed" the resources.
+	// It is a summary of the Conditions
 	State KoBuilderState `json:"state,omitempty"`
+	// Conditions are the latest observations of the KoBuilder state
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []KoBuilderCondition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+	// ObservedGeneration is the generation of the KoBuilder last processed by the operator
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Run is the number of the last run, whose Job is named <name>-job-<run>
+	Run int64 `json:"run,omitempty"`
+	// ConfigHash is a hash of the configuration built by the last run
+	ConfigHash string `json:"configHash,omitempty"`
+	// Checkout is the branch / commit / tag of the repository built by the last run
+	Checkout string `json:"checkout,omitempty"`
+	// Commit is the commit SHA built by the last run, reported by the builder or resolved from Checkout
+	// by the operator when the run is created
+	Commit string `json:"commit,omitempty"`
+	// StartTime is the time the last run started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is the time the last run completed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// TrackedBranch is the tracked branch at the last resolution of its head
+	TrackedBranch string `json:"trackedBranch,omitempty"`
+	// TrackedCommit is the commit SHA of the head of the tracked branch at the last resolution
+	TrackedCommit string `json:"trackedCommit,omitempty"`
+	// LastTrackTime is the time of the last resolution of the head of the tracked branch
+	LastTrackTime *metav1.Time `json:"lastTrackTime,omitempty"`
+	// History contains the last revisions, most recent last
+	History []KoBuilderRevision `json:"history,omitempty"`
+	// Attempts is the number of consecutive failed builds of the current configuration
+	Attempts int32 `json:"attempts,omitempty"`
+	// LastFailureReason is the reason of the last failed build
+	LastFailureReason string `json:"lastFailureReason,omitempty"`
+	// NextRetryTime is the time the next retry of the failed build is scheduled at
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+	// AutoRollback describes the last automatic rollback, if any
+	AutoRollback *AutoRollbackStatus `json:"autoRollback,omitempty"`
+	// LastRebuildToken is the last value of the ko.feloy.dev/rebuild annotation handled by the operator
+	LastRebuildToken string `json:"lastRebuildToken,omitempty"`
+	// Applied is the inventory of the objects applied by the operator, in Operator apply mode:
+	// the objects applied by the last successful run and the objects not pruned yet
+	Applied []AppliedObject `json:"applied,omitempty"`
+	// AppliedRun is the number of the last run whose manifests have been applied by the operator
+	AppliedRun int64 `json:"appliedRun,omitempty"`
+}
+
+// AppliedObject references an object applied by the operator
+type AppliedObject struct {
+	// APIVersion is the API version of the object
+	APIVersion string `json:"apiVersion"`
+	// Kind is the kind of the object
+	Kind string `json:"kind"`
+	// Namespace is the namespace of the object
+	Namespace string `json:"namespace,omitempty"`
+	// Name is the name of the object
+	Name string `json:"name"`
 }
 
 // +kubebuilder:object:root=true
@@ -69,6 +409,12 @@ type KoBuilderStatus struct {
 // +kubebuilder:printcolumn:name="Repository",type=string,JSONPath=`.spec.repository`
 // +kubebuilder:printcolumn:name="Checkout",type=string,JSONPath=`.spec.checkout`
 // +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+// +kubebuilder:printcolumn:name="Suspended",type=boolean,JSONPath=`.spec.suspend`
+// +kubebuilder:printcolumn:name="Built",type=string,JSONPath=`.status.checkout`
+// +kubebuilder:printcolumn:name="Commit",type=string,JSONPath=`.status.commit`,priority=1
+// +kubebuilder:printcolumn:name="Observed",type=integer,JSONPath=`.status.observedGeneration`,priority=1
+// +kubebuilder:printcolumn:name="Started",type=date,JSONPath=`.status.startTime`,priority=1
+// +kubebuilder:printcolumn:name="Completed",type=date,JSONPath=`.status.completionTime`
 
 // KoBuilder is the Schema for the kobuilders API
 type KoBuilder struct {
@@ -0,0 +1,337 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var kobuilderlog = logf.Log.WithName("kobuilder-resource")
+
+func (r *KoBuilder) SetupWebhookWithManager(mgr ctrl.Manager, defaults KoBuilderDefaults) error {
+	mgr.GetWebhookServer().Register("/mutate-ko-feloy-dev-v1alpha1-kobuilder", &webhook.Admission{
+		Handler: &KoBuilderDefaulter{
+			Client:   mgr.GetClient(),
+			Defaults: defaults,
+		},
+	})
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+const (
+	// DefaultRegistryAnnotation is the annotation of a namespace giving the default registry of its KoBuilders
+	DefaultRegistryAnnotation = "ko.feloy.dev/default-registry"
+	// DefaultServiceAccountAnnotation is the annotation of a namespace giving the default service account of its KoBuilders
+	DefaultServiceAccountAnnotation = "ko.feloy.dev/default-service-account"
+)
+
+// KoBuilderDefaults are the default values of the KoBuilder spec fields
+// +kubebuilder:object:generate=false
+type KoBuilderDefaults struct {
+	Registry       string
+	ServiceAccount string
+	Checkout       string
+	ConfigPath     string
+}
+
+// SetDefaults sets the fields of the spec not specified to their default value
+func (r *KoBuilder) SetDefaults(defaults KoBuilderDefaults) {
+	if r.Spec.Registry == "" {
+		r.Spec.Registry = defaults.Registry
+	}
+	if r.Spec.ServiceAccount == "" {
+		r.Spec.ServiceAccount = defaults.ServiceAccount
+	}
+	if r.Spec.Checkout == "" {
+		r.Spec.Checkout = defaults.Checkout
+	}
+	if r.Spec.ConfigPath == "" {
+		r.Spec.ConfigPath = defaults.ConfigPath
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-ko-feloy-dev-v1alpha1-kobuilder,mutating=true,failurePolicy=fail,groups=ko.feloy.dev,resources=kobuilders,versions=v1alpha1,name=mkobuilder.kb.io
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// KoBuilderDefaulter sets the default values of the KoBuilder specs,
+// from the annotations of their namespace or from the operator defaults
+// +kubebuilder:object:generate=false
+type KoBuilderDefaulter struct {
+	Client   client.Client
+	Defaults KoBuilderDefaults
+	decoder  *admission.Decoder
+}
+
+// Handle implements admission.Handler
+func (d *KoBuilderDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	kobuilder := new(KoBuilder)
+	if err := d.decoder.Decode(req, kobuilder); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	kobuilderlog.Info("default", "name", kobuilder.Name)
+
+	defaults := d.Defaults
+	namespace := new(corev1.Namespace)
+	if err := d.Client.Get(ctx, types.NamespacedName{Name: req.Namespace}, namespace); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if registry, ok := namespace.Annotations[DefaultRegistryAnnotation]; ok {
+		defaults.Registry = registry
+	}
+	if serviceAccount, ok := namespace.Annotations[DefaultServiceAccountAnnotation]; ok {
+		defaults.ServiceAccount = serviceAccount
+	}
+	kobuilder.SetDefaults(defaults)
+
+	marshaled, err := json.Marshal(kobuilder)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// InjectDecoder implements admission.DecoderInjector
+func (d *KoBuilderDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-ko-feloy-dev-v1alpha1-kobuilder,mutating=false,failurePolicy=fail,groups=ko.feloy.dev,resources=kobuilders,versions=v1alpha1,name=vkobuilder.kb.io
+
+var _ webhook.Validator = &KoBuilder{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *KoBuilder) ValidateCreate() error {
+	kobuilderlog.Info("validate create", "name", r.Name)
+	return r.validateKoBuilder()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *KoBuilder) ValidateUpdate(old runtime.Object) error {
+	kobuilderlog.Info("validate update", "name", r.Name)
+	return r.validateKoBuilder()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *KoBuilder) ValidateDelete() error {
+	return nil
+}
+
+func (r *KoBuilder) validateKoBuilder() error {
+	allErrs := r.Spec.validate(field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "KoBuilder"},
+		r.Name, allErrs)
+}
+
+var (
+	// registryRegexp matches an image repository without tag nor digest, as "eu.gcr.io/project" or "localhost:5000"
+	registryRegexp = regexp.MustCompile(`^(?:` + registryDomain + `/)?` + registryName + `(?:/` + registryName + `)*$|^` + registryDomain + `:[0-9]+$`)
+	// repositoryRegexp matches a repository path as "github.com/user/repo"
+	repositoryRegexp = regexp.MustCompile(`^[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)+(?::[0-9]+)?(?:/[a-zA-Z0-9._~-]+)+$`)
+)
+
+const (
+	registryDomain = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?`
+	registryName   = `[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*`
+)
+
+func (s *KoBuilderSpec) validate(path *field.Path) (allErrs field.ErrorList) {
+	if s.Registry == "" {
+		allErrs = append(allErrs, field.Required(path.Child("registry"), "the registry on which to push images is required"))
+	} else if !registryRegexp.MatchString(s.Registry) {
+		allErrs = append(allErrs, field.Invalid(path.Child("registry"), s.Registry, "must be an image repository without tag nor digest, as eu.gcr.io/project"))
+	}
+
+	if s.Repository == "" {
+		allErrs = append(allErrs, field.Required(path.Child("repository"), "the repository containing the sources is required"))
+	} else if !repositoryRegexp.MatchString(s.Repository) {
+		allErrs = append(allErrs, field.Invalid(path.Child("repository"), s.Repository, "must be a repository path without scheme, as github.com/user/repo"))
+	}
+
+	if s.GitAuth != nil {
+		gitAuthPath := path.Child("gitAuth")
+		switch s.GitAuth.Type {
+		case SSHGitAuth, TokenGitAuth:
+		default:
+			allErrs = append(allErrs, field.NotSupported(gitAuthPath.Child("type"), s.GitAuth.Type,
+				[]string{string(SSHGitAuth), string(TokenGitAuth)}))
+		}
+		if s.GitAuth.SecretName == "" {
+			allErrs = append(allErrs, field.Required(gitAuthPath.Child("secretName"), "the secret containing the credentials is required"))
+		}
+	}
+
+	if s.Checkout == "" {
+		allErrs = append(allErrs, field.Required(path.Child("checkout"), "the branch / commit / tag to checkout is required"))
+	} else if msg := validateGitRef(s.Checkout); msg != "" {
+		allErrs = append(allErrs, field.Invalid(path.Child("checkout"), s.Checkout, msg))
+	}
+
+	if s.Track != nil {
+		trackPath := path.Child("track")
+		if s.Track.Branch == "" {
+			allErrs = append(allErrs, field.Required(trackPath.Child("branch"), "the branch to track is required"))
+		} else if msg := validateGitRef(s.Track.Branch); msg != "" {
+			allErrs = append(allErrs, field.Invalid(trackPath.Child("branch"), s.Track.Branch, msg))
+		}
+		if s.Track.Interval != nil && s.Track.Interval.Duration < time.Minute {
+			allErrs = append(allErrs, field.Invalid(trackPath.Child("interval"), s.Track.Interval.Duration.String(), "must be at least 1m"))
+		}
+		if s.GitAuth != nil && s.GitAuth.Type == SSHGitAuth {
+			allErrs = append(allErrs, field.Forbidden(trackPath, "tracking a repository accessed with ssh credentials is not supported"))
+		}
+	}
+
+	if s.ConfigPath == "" {
+		allErrs = append(allErrs, field.Required(path.Child("configPath"), "the path containing the manifests is required"))
+	} else if msg := validateConfigPath(s.ConfigPath); msg != "" {
+		allErrs = append(allErrs, field.Invalid(path.Child("configPath"), s.ConfigPath, msg))
+	}
+
+	if s.RegistryCredentials != nil {
+		credentialsPath := path.Child("registryCredentials")
+		switch s.RegistryCredentials.Type {
+		case GCPCredentials, DockerConfigCredentials, BasicCredentials:
+		default:
+			allErrs = append(allErrs, field.NotSupported(credentialsPath.Child("type"), s.RegistryCredentials.Type,
+				[]string{string(GCPCredentials), string(DockerConfigCredentials), string(BasicCredentials)}))
+		}
+		if s.RegistryCredentials.SecretName == "" {
+			allErrs = append(allErrs, field.Required(credentialsPath.Child("secretName"), "the secret containing the credentials is required"))
+		}
+	}
+
+	if s.RetryPolicy != nil {
+		retryPath := path.Child("retryPolicy")
+		if s.RetryPolicy.MaxRetries < 0 {
+			allErrs = append(allErrs, field.Invalid(retryPath.Child("maxRetries"), s.RetryPolicy.MaxRetries, "must be positive"))
+		}
+		if s.RetryPolicy.InitialBackoff != nil && s.RetryPolicy.InitialBackoff.Duration < time.Second {
+			allErrs = append(allErrs, field.Invalid(retryPath.Child("initialBackoff"), s.RetryPolicy.InitialBackoff.Duration.String(), "must be at least 1s"))
+		}
+		if s.RetryPolicy.MaxBackoff != nil && s.RetryPolicy.MaxBackoff.Duration < time.Second {
+			allErrs = append(allErrs, field.Invalid(retryPath.Child("maxBackoff"), s.RetryPolicy.MaxBackoff.Duration.String(), "must be at least 1s"))
+		}
+	}
+
+	if s.Timeout != nil && s.Timeout.Duration < time.Second {
+		allErrs = append(allErrs, field.Invalid(path.Child("timeout"), s.Timeout.Duration.String(), "must be at least 1s"))
+	}
+	if s.PendingTimeout != nil && s.PendingTimeout.Duration < time.Second {
+		allErrs = append(allErrs, field.Invalid(path.Child("pendingTimeout"), s.PendingTimeout.Duration.String(), "must be at least 1s"))
+	}
+
+	if s.Logs != nil && s.Logs.FailedJobTTL != nil && s.Logs.FailedJobTTL.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("logs", "failedJobTTL"), s.Logs.FailedJobTTL.Duration.String(), "must be positive"))
+	}
+
+	if s.HealthCheck != nil && s.HealthCheck.Timeout != nil && s.HealthCheck.Timeout.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("healthCheck", "timeout"), s.HealthCheck.Timeout.Duration.String(), "must be positive"))
+	}
+
+	if s.ApplyMode == OperatorApply && (s.Builder == nil || s.Builder.Image == "" || s.Builder.Image == DefaultBuilderImage) {
+		allErrs = append(allErrs, field.Required(path.Child("builder", "image"),
+			"the Operator apply mode requires a builder image reporting the resolved manifests, which "+DefaultBuilderImage+" does not"))
+	}
+	if s.Prune && s.ApplyMode != OperatorApply {
+		allErrs = append(allErrs, field.Forbidden(path.Child("prune"), "pruning is only supported with the Operator apply mode"))
+	}
+	if s.DriftPolicy != "" && s.DriftPolicy != IgnoreDrift && s.ApplyMode != OperatorApply {
+		allErrs = append(allErrs, field.Forbidden(path.Child("driftPolicy"), "drift detection is only supported with the Operator apply mode"))
+	}
+
+	if s.RevisionHistoryLimit != nil && *s.RevisionHistoryLimit < 1 {
+		allErrs = append(allErrs, field.Invalid(path.Child("revisionHistoryLimit"), *s.RevisionHistoryLimit, "must be at least 1"))
+	}
+	if s.RollbackTo != nil && *s.RollbackTo < 1 {
+		allErrs = append(allErrs, field.Invalid(path.Child("rollbackTo"), *s.RollbackTo, "must be the number of a revision of the history"))
+	}
+	return
+}
+
+// validateGitRef returns a message explaining why ref is not a valid git ref,
+// following the rules of git check-ref-format, or an empty string if it is valid
+func validateGitRef(ref string) string {
+	switch {
+	case ref == "@":
+		return "must not be the single character @"
+	case strings.HasPrefix(ref, "-"):
+		return "must not begin with -"
+	case strings.HasPrefix(ref, "/") || strings.HasSuffix(ref, "/") || strings.Contains(ref, "//"):
+		return "must not begin or end with / nor contain consecutive /"
+	case strings.HasSuffix(ref, "."):
+		return "must not end with ."
+	case strings.Contains(ref, ".."):
+		return "must not contain .."
+	case strings.Contains(ref, "@{"):
+		return "must not contain @{"
+	case strings.ContainsAny(ref, " ~^:?*[\\"):
+		return "must not contain spaces nor any of ~ ^ : ? * [ \\"
+	}
+	for _, c := range ref {
+		if c < 0x20 || c == 0x7f {
+			return "must not contain control characters"
+		}
+	}
+	for _, component := range strings.Split(ref, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return "components must not begin with . nor end with .lock"
+		}
+	}
+	return ""
+}
+
+// validateConfigPath returns a message explaining why p is not a valid path
+// in the repository, or an empty string if it is valid.
+// The path is relative to the root of the repository, a leading / designating this root
+func validateConfigPath(p string) string {
+	if strings.Contains(p, "://") {
+		return "must be a path in the repository, not an URL"
+	}
+	if strings.ContainsAny(p, "\\~") {
+		return "must not contain \\ nor ~"
+	}
+	for _, component := range strings.Split(p, "/") {
+		if component == ".." {
+			return "must be relative to the root of the repository and must not contain .."
+		}
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func validKoBuilder() *KoBuilder {
+	return &KoBuilder{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-ko-builder",
+			Namespace: "my-ns",
+		},
+		Spec: KoBuilderSpec{
+			Registry:       "eu.gcr.io/ko-demo",
+			ServiceAccount: "ko-builder-sa@ko-demo.iam.gserviceaccount.com",
+			Repository:     "github.com/feloy/kopond",
+			Checkout:       "2.1.0",
+			ConfigPath:     "/config",
+		},
+	}
+}
+
+func TestValidateKoBuilder(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*KoBuilderSpec)
+		valid  bool
+	}{
+		{"valid", func(s *KoBuilderSpec) {}, true},
+		{"docker hub registry", func(s *KoBuilderSpec) { s.Registry = "user/ko-builder" }, true},
+		{"local registry", func(s *KoBuilderSpec) { s.Registry = "localhost:5000" }, true},
+		{"local registry with path", func(s *KoBuilderSpec) { s.Registry = "localhost:5000/apps" }, true},
+		{"missing registry", func(s *KoBuilderSpec) { s.Registry = "" }, false},
+		{"registry with tag", func(s *KoBuilderSpec) { s.Registry = "eu.gcr.io/ko-demo:latest" }, false},
+		{"registry with uppercase", func(s *KoBuilderSpec) { s.Registry = "eu.gcr.io/KoDemo" }, false},
+		{"registry with scheme", func(s *KoBuilderSpec) { s.Registry = "https://eu.gcr.io/ko-demo" }, false},
+		{"missing repository", func(s *KoBuilderSpec) { s.Repository = "" }, false},
+		{"repository with scheme", func(s *KoBuilderSpec) { s.Repository = "https://github.com/feloy/kopond" }, false},
+		{"repository without host", func(s *KoBuilderSpec) { s.Repository = "feloy/kopond" }, false},
+		{"missing checkout", func(s *KoBuilderSpec) { s.Checkout = "" }, false},
+		{"branch checkout", func(s *KoBuilderSpec) { s.Checkout = "feature/my-branch" }, true},
+		{"commit checkout", func(s *KoBuilderSpec) { s.Checkout = "0123456789abcdef0123456789abcdef01234567" }, true},
+		{"checkout with space", func(s *KoBuilderSpec) { s.Checkout = "my branch" }, false},
+		{"checkout with double dot", func(s *KoBuilderSpec) { s.Checkout = "v1..v2" }, false},
+		{"checkout ending with .lock", func(s *KoBuilderSpec) { s.Checkout = "main.lock" }, false},
+		{"checkout starting with dash", func(s *KoBuilderSpec) { s.Checkout = "-f" }, false},
+		{"checkout with reflog", func(s *KoBuilderSpec) { s.Checkout = "main@{1}" }, false},
+		{"missing configPath", func(s *KoBuilderSpec) { s.ConfigPath = "" }, false},
+		{"relative configPath", func(s *KoBuilderSpec) { s.ConfigPath = "deploy/config" }, true},
+		{"traversing configPath", func(s *KoBuilderSpec) { s.ConfigPath = "/config/../../etc" }, false},
+		{"URL configPath", func(s *KoBuilderSpec) { s.ConfigPath = "https://example.com/config" }, false},
+		{"dockerconfig credentials", func(s *KoBuilderSpec) {
+			s.RegistryCredentials = &RegistryCredentials{Type: DockerConfigCredentials, SecretName: "regcred"}
+		}, true},
+		{"credentials without secret", func(s *KoBuilderSpec) {
+			s.RegistryCredentials = &RegistryCredentials{Type: BasicCredentials}
+		}, false},
+		{"unknown credentials type", func(s *KoBuilderSpec) {
+			s.RegistryCredentials = &RegistryCredentials{Type: "aws", SecretName: "regcred"}
+		}, false},
+		{"ssh git auth", func(s *KoBuilderSpec) {
+			s.GitAuth = &GitAuth{Type: SSHGitAuth, SecretName: "git-ssh"}
+		}, true},
+		{"git auth without secret", func(s *KoBuilderSpec) {
+			s.GitAuth = &GitAuth{Type: TokenGitAuth}
+		}, false},
+		{"retry policy", func(s *KoBuilderSpec) {
+			s.RetryPolicy = &RetryPolicy{MaxRetries: 3, InitialBackoff: &metav1.Duration{Duration: 10 * time.Second}}
+		}, true},
+		{"retry policy with too short backoff", func(s *KoBuilderSpec) {
+			s.RetryPolicy = &RetryPolicy{MaxRetries: 3, MaxBackoff: &metav1.Duration{Duration: time.Millisecond}}
+		}, false},
+		{"timeout", func(s *KoBuilderSpec) { s.Timeout = &metav1.Duration{Duration: 30 * time.Minute} }, true},
+		{"negative timeout", func(s *KoBuilderSpec) { s.Timeout = &metav1.Duration{Duration: -time.Minute} }, false},
+		{"invalid rollbackTo", func(s *KoBuilderSpec) { n := int64(0); s.RollbackTo = &n }, false},
+		{"revision history limit", func(s *KoBuilderSpec) { n := int32(1); s.RevisionHistoryLimit = &n }, true},
+		{"empty revision history", func(s *KoBuilderSpec) { n := int32(0); s.RevisionHistoryLimit = &n }, false},
+		{"failed job TTL", func(s *KoBuilderSpec) { s.Logs = &BuildLogsSpec{FailedJobTTL: &metav1.Duration{Duration: time.Hour}} }, true},
+		{"negative failed job TTL", func(s *KoBuilderSpec) {
+			s.Logs = &BuildLogsSpec{FailedJobTTL: &metav1.Duration{Duration: -time.Hour}}
+		}, false},
+		{"disabled health check", func(s *KoBuilderSpec) { s.HealthCheck = &HealthCheckSpec{Timeout: &metav1.Duration{}} }, true},
+		{"negative health check timeout", func(s *KoBuilderSpec) {
+			s.HealthCheck = &HealthCheckSpec{Timeout: &metav1.Duration{Duration: -time.Minute}}
+		}, false},
+		{"Operator apply mode", func(s *KoBuilderSpec) {
+			s.ApplyMode = OperatorApply
+			s.Builder = &BuilderSpec{Image: "my-registry/ko-builder:manifests"}
+		}, true},
+		{"Operator apply mode with the default builder image", func(s *KoBuilderSpec) { s.ApplyMode = OperatorApply }, false},
+		{"Operator apply mode with the explicit default builder image", func(s *KoBuilderSpec) {
+			s.ApplyMode = OperatorApply
+			s.Builder = &BuilderSpec{Image: DefaultBuilderImage}
+		}, false},
+		{"prune", func(s *KoBuilderSpec) {
+			s.ApplyMode = OperatorApply
+			s.Builder = &BuilderSpec{Image: "my-registry/ko-builder:manifests"}
+			s.Prune = true
+		}, true},
+		{"prune in Builder apply mode", func(s *KoBuilderSpec) { s.Prune = true }, false},
+		{"drift policy", func(s *KoBuilderSpec) {
+			s.ApplyMode = OperatorApply
+			s.Builder = &BuilderSpec{Image: "my-registry/ko-builder:manifests"}
+			s.DriftPolicy = ReapplyDrift
+		}, true},
+		{"drift policy in Builder apply mode", func(s *KoBuilderSpec) { s.DriftPolicy = ReportDrift }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kobuilder := validKoBuilder()
+			tt.modify(&kobuilder.Spec)
+			err := kobuilder.ValidateCreate()
+			if tt.valid && err != nil {
+				t.Errorf("expected valid, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected invalid, got no error")
+			}
+		})
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	defaults := KoBuilderDefaults{
+		Registry:       "eu.gcr.io/default",
+		ServiceAccount: "default@project.com",
+		Checkout:       "HEAD",
+		ConfigPath:     "/config",
+	}
+
+	kobuilder := &KoBuilder{
+		Spec: KoBuilderSpec{
+			Repository: "github.com/feloy/kopond",
+		},
+	}
+	kobuilder.SetDefaults(defaults)
+	expected := KoBuilderSpec{
+		Registry:       "eu.gcr.io/default",
+		ServiceAccount: "default@project.com",
+		Repository:     "github.com/feloy/kopond",
+		Checkout:       "HEAD",
+		ConfigPath:     "/config",
+	}
+	if !reflect.DeepEqual(kobuilder.Spec, expected) {
+		t.Errorf("expected %+v, got %+v", expected, kobuilder.Spec)
+	}
+
+	kobuilder = validKoBuilder()
+	expected = kobuilder.Spec
+	kobuilder.SetDefaults(defaults)
+	if !reflect.DeepEqual(kobuilder.Spec, expected) {
+		t.Errorf("expected specified values to be kept, got %+v", kobuilder.Spec)
+	}
+}
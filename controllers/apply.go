@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// fieldManager is the field manager of the objects applied by the operator
+	fieldManager = "ko-operator"
+	// manifestsKey is the key of the manifests ConfigMap containing the resolved manifests
+	manifestsKey = "manifests.yaml"
+
+	componentManifests = "manifests"
+)
+
+// applicableKinds are the kinds of the objects the operator can apply, all namespaced.
+// The operator is only granted to manage these kinds, and never the cluster-scoped or RBAC kinds
+var applicableKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "Deployment"}:                     true,
+	{Group: "apps", Kind: "StatefulSet"}:                    true,
+	{Group: "apps", Kind: "DaemonSet"}:                      true,
+	{Group: "", Kind: "Service"}:                            true,
+	{Group: "", Kind: "ConfigMap"}:                          true,
+	{Group: "", Kind: "ServiceAccount"}:                     true,
+	{Group: "batch", Kind: "Job"}:                           true,
+	{Group: "batch", Kind: "CronJob"}:                       true,
+	{Group: "autoscaling", Kind: "HorizontalPodAutoscaler"}: true,
+	{Group: "policy", Kind: "PodDisruptionBudget"}:          true,
+	{Group: "networking.k8s.io", Kind: "Ingress"}:           true,
+	{Group: "extensions", Kind: "Ingress"}:                  true,
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=services;configmaps;serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io;extensions,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+
+func applyMode(kobuilder *kov1alpha1.KoBuilder) kov1alpha1.ApplyMode {
+	if kobuilder.Spec.ApplyMode == "" {
+		return kov1alpha1.BuilderApply
+	}
+	return kobuilder.Spec.ApplyMode
+}
+
+// manifestsConfigMapName returns the name of the ConfigMap in which the builder of the given run
+// reports the resolved manifests, in Operator apply mode
+func manifestsConfigMapName(kobuilder *kov1alpha1.KoBuilder, run int64) string {
+	return fmt.Sprintf("%s-manifests-%d", kobuilder.Name, run)
+}
+
+// applyManifests applies with server-side apply the manifests reported by the builder of the completed job,
+// owned by kobuilder, and returns the list of the applied objects
+func (r *KoBuilderReconciler) applyManifests(ctx context.Context, kobuilder *kov1alpha1.KoBuilder, job *batchv1.Job) (applied []kov1alpha1.AppliedObject, err error) {
+	run := runOf(job.Labels)
+	manifests := new(corev1.ConfigMap)
+	if err = r.Get(ctx, types.NamespacedName{Name: manifestsConfigMapName(kobuilder, run), Namespace: kobuilder.Namespace}, manifests); err != nil {
+		return nil, fmt.Errorf("unable to get the manifests reported by the builder: %v", err)
+	}
+
+	// adopt the manifests ConfigMap created by the builder, for it to be deleted with its run
+	if manifests.Labels[labelComponent] != componentManifests {
+		manifests.Labels = runLabels(kobuilder, run, componentManifests)
+		controllerutil.SetControllerReference(kobuilder, manifests, r.Scheme)
+		if err = r.Update(ctx, manifests); err != nil {
+			return
+		}
+	}
+
+	var objects []*unstructured.Unstructured
+	if objects, err = ownedManifests(kobuilder, manifests); err != nil {
+		return
+	}
+	// check all the objects before applying any of them
+	for _, object := range objects {
+		if err = r.checkApplicable(object); err != nil {
+			return
+		}
+	}
+
+	for _, object := range objects {
+		if err = r.Patch(ctx, object, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+			return nil, fmt.Errorf("unable to apply %s %s: %v", object.GetKind(), object.GetName(), err)
+		}
+		applied = append(applied, kov1alpha1.AppliedObject{
+			APIVersion: object.GetAPIVersion(),
+			Kind:       object.GetKind(),
+			Namespace:  object.GetNamespace(),
+			Name:       object.GetName(),
+		})
+	}
+	return
+}
+
+// checkApplicable returns an error if object is not of one of the applicable kinds,
+// or if its kind is not namespaced in the cluster
+func (r *KoBuilderReconciler) checkApplicable(object *unstructured.Unstructured) error {
+	gvk := object.GroupVersionKind()
+	if !applicableKinds[gvk.GroupKind()] {
+		return fmt.Errorf("%s %s cannot be applied by the operator, the kind %s is not supported in Operator apply mode",
+			object.GetKind(), object.GetName(), gvk.GroupKind())
+	}
+	mapping, err := r.RESTMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return fmt.Errorf("unable to find the resource of %s %s: %v", object.GetKind(), object.GetName(), err)
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return fmt.Errorf("%s %s cannot be applied by the operator, the kind %s is not namespaced",
+			object.GetKind(), object.GetName(), gvk.GroupKind())
+	}
+	return nil
+}
+
+// ownedManifests returns the objects of the manifests ConfigMap, owned by kobuilder, as applied by the operator
+func ownedManifests(kobuilder *kov1alpha1.KoBuilder, manifests *corev1.ConfigMap) (objects []*unstructured.Unstructured, err error) {
+	if objects, err = decodeManifests(manifests.Data[manifestsKey], kobuilder.Namespace); err != nil {
+		return
+	}
+	owner := metav1.OwnerReference{
+		APIVersion: kov1alpha1.GroupVersion.String(),
+		Kind:       "KoBuilder",
+		Name:       kobuilder.Name,
+		UID:        kobuilder.UID,
+	}
+	for _, object := range objects {
+		object.SetOwnerReferences([]metav1.OwnerReference{owner})
+	}
+	return
+}
+
+// decodeManifests decodes the objects of the YAML or JSON documents of data,
+// placed in namespace, the only namespace they can be applied to
+func decodeManifests(data string, namespace string) (objects []*unstructured.Unstructured, err error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(data), 4096)
+	for {
+		object := new(unstructured.Unstructured)
+		if err = decoder.Decode(&object.Object); err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("unable to decode the manifests: %v", err)
+		}
+		if len(object.Object) == 0 {
+			// empty document
+			continue
+		}
+		if object.GetAPIVersion() == "" || object.GetKind() == "" || object.GetName() == "" {
+			return nil, fmt.Errorf("invalid manifest, apiVersion, kind and name are required: %v", object.Object)
+		}
+		switch object.GetNamespace() {
+		case "":
+			object.SetNamespace(namespace)
+		case namespace:
+		default:
+			return nil, fmt.Errorf("%s %s cannot be applied to namespace %s, only to namespace %s",
+				object.GetKind(), object.GetName(), object.GetNamespace(), namespace)
+		}
+		objects = append(objects, object)
+	}
+}
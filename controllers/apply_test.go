@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("Manifests applied by the operator", func() {
+
+	It("should decode the objects of the documents in the namespace of the KoBuilder", func() {
+		objects, err := decodeManifests(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: echo-service
+---
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: echo-controller
+  namespace: my-ns
+spec:
+  replicas: 1
+`, "my-ns")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(objects).To(HaveLen(2))
+		Expect(objects[0].GetKind()).To(Equal("Service"))
+		Expect(objects[0].GetNamespace()).To(Equal("my-ns"))
+		Expect(objects[1].GetAPIVersion()).To(Equal("apps/v1"))
+		Expect(objects[1].GetName()).To(Equal("echo-controller"))
+	})
+
+	It("should refuse objects of another namespace", func() {
+		_, err := decodeManifests(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: echo-service
+  namespace: other-ns
+`, "my-ns")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should refuse incomplete objects", func() {
+		_, err := decodeManifests(`
+kind: Service
+metadata:
+  name: echo-service
+`, "my-ns")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should only apply the supported namespaced kinds", func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+		mapper.Add(schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Service"}, meta.RESTScopeRoot)
+		mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRoleBinding"}, meta.RESTScopeRoot)
+		r := &KoBuilderReconciler{RESTMapper: mapper}
+		object := func(apiVersion, kind string) *unstructured.Unstructured {
+			o := new(unstructured.Unstructured)
+			o.SetAPIVersion(apiVersion)
+			o.SetKind(kind)
+			o.SetName("echo")
+			return o
+		}
+
+		Expect(r.checkApplicable(object("apps/v1", "Deployment"))).To(Succeed())
+		Expect(r.checkApplicable(object("rbac.authorization.k8s.io/v1", "ClusterRoleBinding"))).ToNot(Succeed())
+		// a supported kind mapped as cluster-scoped
+		Expect(r.checkApplicable(object("v1", "Service"))).ToNot(Succeed())
+		// a supported kind unknown to the cluster
+		Expect(r.checkApplicable(object("batch/v1beta1", "CronJob"))).ToNot(Succeed())
+	})
+})
@@ -1,24 +1,25 @@
 package controllers
 
 import (
-	"fmt"
-
 	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func createConfigMap(kobuilder *kov1alpha1.KoBuilder) *corev1.ConfigMap {
-	return &corev1.ConfigMap{
+// createConfigMap returns the ConfigMap of the given run of kobuilder, passed as env to the ko-builder pod
+func createConfigMap(kobuilder *kov1alpha1.KoBuilder, checkout string, run int64) *corev1.ConfigMap {
+	config := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-config", kobuilder.Name),
+			Name:      configMapName(kobuilder, run),
 			Namespace: kobuilder.Namespace,
+			Labels:    runLabels(kobuilder, run, componentConfig),
 		},
 		Data: map[string]string{
 			"REGISTRY":         kobuilder.Spec.Registry,
 			"SERVICE_ACCOUNT":  kobuilder.Spec.ServiceAccount,
+			"REGISTRY_AUTH":    string(registryCredentials(kobuilder).Type),
 			"REPOSITORY":       kobuilder.Spec.Repository,
-			"CHECKOUT":         kobuilder.Spec.Checkout,
+			"CHECKOUT":         checkout,
 			"CONFIG_PATH":      kobuilder.Spec.ConfigPath,
 			"OWNER_APIVERSION": "kobuilders.ko.feloy.dev",
 			"OWNER_CONTROLLER": "false",
@@ -27,4 +28,9 @@ func createConfigMap(kobuilder *kov1alpha1.KoBuilder) *corev1.ConfigMap {
 			"OWNER_UID":        string(kobuilder.UID),
 		},
 	}
+	if applyMode(kobuilder) == kov1alpha1.OperatorApply {
+		// the builder only builds and pushes the images, and reports the resolved manifests
+		config.Data["APPLY_MODE"] = string(kov1alpha1.OperatorApply)
+	}
+	return config
 }
@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// driftResyncInterval is the interval between two drift checks of a KoBuilder when none of its applied objects changes
+const driftResyncInterval = 10 * time.Minute
+
+func driftPolicy(kobuilder *kov1alpha1.KoBuilder) kov1alpha1.DriftPolicy {
+	if kobuilder.Spec.DriftPolicy == "" {
+		return kov1alpha1.IgnoreDrift
+	}
+	return kobuilder.Spec.DriftPolicy
+}
+
+// driftChecks tracks the KoBuilders whose drifts have to be checked: the owners of the applied objects
+// which have changed, and the KoBuilders not checked since the drift resync interval
+type driftChecks struct {
+	lock    sync.Mutex
+	changed map[types.NamespacedName]bool
+	checked map[types.NamespacedName]time.Time
+}
+
+// markChanged records that an object applied for the KoBuilder key has changed
+func (d *driftChecks) markChanged(key types.NamespacedName) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.changed == nil {
+		d.changed = map[types.NamespacedName]bool{}
+	}
+	d.changed[key] = true
+}
+
+// due returns true if the drifts of the KoBuilder key have to be checked now, the check being then recorded,
+// or the duration after which they have to be checked
+func (d *driftChecks) due(key types.NamespacedName, now time.Time) (due bool, wait time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.checked == nil {
+		d.checked = map[types.NamespacedName]time.Time{}
+	}
+	if checked, ok := d.checked[key]; ok && !d.changed[key] {
+		if elapsed := now.Sub(checked); elapsed < driftResyncInterval {
+			return false, driftResyncInterval - elapsed
+		}
+	}
+	delete(d.changed, key)
+	d.checked[key] = now
+	return true, driftResyncInterval
+}
+
+// forget forgets the deleted KoBuilder key
+func (d *driftChecks) forget(key types.NamespacedName) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.changed, key)
+	delete(d.checked, key)
+}
+
+// watchInventory starts watching the kinds of the objects in the inventory of kobuilder not watched yet,
+// for the drifts of a changed object to be checked by the reconciliation of its owner.
+// The kinds are watched in all the namespaces, with the cache of the manager, until the operator is restarted
+func (r *KoBuilderReconciler) watchInventory(kobuilder *kov1alpha1.KoBuilder) (err error) {
+	if r.controller == nil || driftPolicy(kobuilder) == kov1alpha1.IgnoreDrift {
+		return
+	}
+	r.watchLock.Lock()
+	defer r.watchLock.Unlock()
+	if r.watchedKinds == nil {
+		r.watchedKinds = map[schema.GroupVersionKind]bool{}
+	}
+	for _, object := range kobuilder.Status.Applied {
+		gvk := schema.FromAPIVersionAndKind(object.APIVersion, object.Kind)
+		if r.watchedKinds[gvk] {
+			continue
+		}
+		watched := new(unstructured.Unstructured)
+		watched.SetGroupVersionKind(gvk)
+		changed := &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.changedOwners)}
+		if err = r.controller.Watch(&source.Kind{Type: watched}, changed, driftPredicate()); err != nil {
+			return fmt.Errorf("unable to watch %s: %v", gvk, err)
+		}
+		r.watchedKinds[gvk] = true
+	}
+	return
+}
+
+// changedOwners returns the requests to reconcile the KoBuilders owning a changed object,
+// whose drifts have to be checked
+func (r *KoBuilderReconciler) changedOwners(o handler.MapObject) (requests []reconcile.Request) {
+	for _, owner := range o.Meta.GetOwnerReferences() {
+		if owner.Kind != "KoBuilder" || owner.APIVersion != kov1alpha1.GroupVersion.String() {
+			continue
+		}
+		key := types.NamespacedName{Name: owner.Name, Namespace: o.Meta.GetNamespace()}
+		r.driftChecks.markChanged(key)
+		requests = append(requests, reconcile.Request{NamespacedName: key})
+	}
+	return
+}
+
+// driftPredicate filters the updates of the applied objects which cannot be drifts: the changes of their status only,
+// detected by an unchanged generation, for the objects whose generation is maintained
+func driftPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.MetaOld == nil || e.MetaNew == nil {
+				return true
+			}
+			return e.MetaNew.GetGeneration() == 0 ||
+				e.MetaNew.GetGeneration() != e.MetaOld.GetGeneration() ||
+				!reflect.DeepEqual(e.MetaNew.GetLabels(), e.MetaOld.GetLabels()) ||
+				!reflect.DeepEqual(e.MetaNew.GetAnnotations(), e.MetaOld.GetAnnotations())
+		},
+	}
+}
+
+// checkDrift detects the drifts of the objects applied by the operator from the last applied manifests,
+// once the workloads of the last run have been checked, when one of the applied objects has changed
+// or after the drift resync interval. The drifts are reported in the Drifted condition of kobuilder
+// or the manifests are applied again, depending on its drift policy.
+// It returns the duration after which the drifts have to be checked again
+func (r *KoBuilderReconciler) checkDrift(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder) (requeueAfter time.Duration, err error) {
+	state := kobuilder.Status.State
+	if applyMode(kobuilder) != kov1alpha1.OperatorApply || driftPolicy(kobuilder) == kov1alpha1.IgnoreDrift ||
+		kobuilder.Status.AppliedRun == 0 || (state != kov1alpha1.Deployed && state != kov1alpha1.Unhealthy) {
+		return
+	}
+	key := types.NamespacedName{Name: kobuilder.Name, Namespace: kobuilder.Namespace}
+	due, requeueAfter := r.driftChecks.due(key, time.Now())
+	if !due {
+		return
+	}
+	defer func() {
+		if err != nil {
+			// checked again by the next reconciliation
+			r.driftChecks.markChanged(key)
+		}
+	}()
+
+	var drifted []*unstructured.Unstructured
+	if drifted, err = r.detectDrift(ctx, kobuilder); err != nil {
+		return
+	}
+
+	condition := kov1alpha1.KoBuilderCondition{
+		Type:    kov1alpha1.DriftedCondition,
+		Status:  corev1.ConditionFalse,
+		Reason:  reasonNoDrift,
+		Message: "The applied objects match the manifests",
+	}
+	switch {
+	case len(drifted) == 0:
+		if !hasCondition(&kobuilder.Status, kov1alpha1.DriftedCondition) {
+			return
+		}
+	case driftPolicy(kobuilder) == kov1alpha1.ReapplyDrift:
+		for _, object := range drifted {
+			if err = r.Patch(ctx, object, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+				err = fmt.Errorf("unable to apply %s %s again: %v", object.GetKind(), object.GetName(), err)
+				return
+			}
+		}
+		condition.Reason = reasonDriftCorrected
+		condition.Message = fmt.Sprintf("%s applied again", driftedObjects(drifted))
+		log.Info(condition.Message)
+		r.Recorder.Eventf(kobuilder, corev1.EventTypeNormal, reasonDriftCorrected, "%s drifted from the manifests of run %d and applied again",
+			driftedObjects(drifted), kobuilder.Status.AppliedRun)
+	default:
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = reasonDrifted
+		condition.Message = fmt.Sprintf("%s drifted from the manifests of run %d", driftedObjects(drifted), kobuilder.Status.AppliedRun)
+	}
+
+	previous := findCondition(&kobuilder.Status, kov1alpha1.DriftedCondition)
+	if previous != nil && previous.Status == condition.Status && previous.Reason == condition.Reason && previous.Message == condition.Message {
+		return
+	}
+	if condition.Status == corev1.ConditionTrue {
+		log.Info(condition.Message)
+		r.Recorder.Event(kobuilder, corev1.EventTypeWarning, reasonDrifted, condition.Message)
+	}
+	setCondition(&kobuilder.Status, condition)
+	err = r.Status().Update(ctx, kobuilder)
+	return
+}
+
+// detectDrift returns the objects of the last applied manifests of kobuilder which have drifted:
+// deleted, or whose fields applied by the operator would change if the manifests were applied again
+func (r *KoBuilderReconciler) detectDrift(ctx context.Context, kobuilder *kov1alpha1.KoBuilder) (drifted []*unstructured.Unstructured, err error) {
+	manifests := new(corev1.ConfigMap)
+	if err = r.Get(ctx, types.NamespacedName{Name: manifestsConfigMapName(kobuilder, kobuilder.Status.AppliedRun), Namespace: kobuilder.Namespace}, manifests); err != nil {
+		return nil, fmt.Errorf("unable to get the last applied manifests: %v", err)
+	}
+	var objects []*unstructured.Unstructured
+	if objects, err = ownedManifests(kobuilder, manifests); err != nil {
+		return
+	}
+
+	for _, object := range objects {
+		live := new(unstructured.Unstructured)
+		live.SetGroupVersionKind(object.GroupVersionKind())
+		if err = r.Get(ctx, types.NamespacedName{Name: object.GetName(), Namespace: object.GetNamespace()}, live); err != nil {
+			if apierrors.IsNotFound(err) {
+				drifted, err = append(drifted, object), nil
+				continue
+			}
+			return
+		}
+		applied := object.DeepCopy()
+		if err = r.Patch(ctx, applied, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership, client.DryRunAll); err != nil {
+			return nil, fmt.Errorf("unable to apply %s %s in dry run: %v", object.GetKind(), object.GetName(), err)
+		}
+		if driftedFrom(live, applied) {
+			drifted = append(drifted, object)
+		}
+	}
+	return
+}
+
+// driftedFrom returns true if the fields applied by the operator differ between the live object
+// and the object applied again in dry run. The fields changed by another manager are not owned by the operator anymore,
+// and the fields removed from the manifests are not applied again
+func driftedFrom(live *unstructured.Unstructured, applied *unstructured.Unstructured) bool {
+	return !reflect.DeepEqual(appliedFields(live), appliedFields(applied))
+}
+
+// appliedFields returns the fields of object applied by the operator, from its managed fields
+func appliedFields(object *unstructured.Unstructured) *metav1.FieldsV1 {
+	for _, entry := range object.GetManagedFields() {
+		if entry.Manager == fieldManager && entry.Operation == metav1.ManagedFieldsOperationApply {
+			return entry.FieldsV1
+		}
+	}
+	return nil
+}
+
+// driftedObjects returns the kinds and names of the drifted objects, for the messages
+func driftedObjects(drifted []*unstructured.Unstructured) string {
+	names := make([]string, 0, len(drifted))
+	for _, object := range drifted {
+		names = append(names, fmt.Sprintf("%s %s", object.GetKind(), object.GetName()))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("Drift detection", func() {
+
+	deployment := func(replicas int64) *unstructured.Unstructured {
+		object := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "echo", "namespace": "my-ns"},
+			"spec":       map[string]interface{}{"replicas": replicas},
+		}}
+		return object
+	}
+
+	It("should only compare the fields applied by the operator", func() {
+		fields := func(raw string) *metav1.FieldsV1 { return &metav1.FieldsV1{Raw: []byte(raw)} }
+		managed := func(replicas int64, entries ...metav1.ManagedFieldsEntry) *unstructured.Unstructured {
+			object := deployment(replicas)
+			object.SetManagedFields(entries)
+			return object
+		}
+		now := metav1.Now()
+		applied := metav1.ManagedFieldsEntry{Manager: fieldManager, Operation: metav1.ManagedFieldsOperationApply, Time: &now,
+			FieldsType: "FieldsV1", FieldsV1: fields(`{"f:spec":{"f:replicas":{}}}`)}
+		autoscaled := metav1.ManagedFieldsEntry{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsType: "FieldsV1", FieldsV1: fields(`{"f:metadata":{"f:annotations":{}}}`)}
+		edited := metav1.ManagedFieldsEntry{Manager: "kubectl-edit", Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsType: "FieldsV1", FieldsV1: fields(`{"f:spec":{"f:replicas":{}}}`)}
+		notOwned := applied
+		notOwned.FieldsV1 = fields(`{}`)
+
+		By("Ignoring the fields set by other managers")
+		Expect(driftedFrom(managed(1, applied, autoscaled), managed(1, applied))).To(BeFalse())
+		By("Detecting the fields changed by other managers")
+		Expect(driftedFrom(managed(3, notOwned, edited), managed(1, applied))).To(BeTrue())
+		By("Detecting the objects not applied by the operator")
+		Expect(driftedFrom(managed(1, edited), managed(1, applied))).To(BeTrue())
+	})
+
+	It("should check the drifts when an applied object changes or after the resync interval", func() {
+		checks := &driftChecks{}
+		key := types.NamespacedName{Name: "my-ko-builder", Namespace: "my-ns"}
+		now := time.Now()
+
+		due, _ := checks.due(key, now)
+		Expect(due).To(BeTrue())
+		due, wait := checks.due(key, now.Add(time.Minute))
+		Expect(due).To(BeFalse())
+		Expect(wait).To(Equal(driftResyncInterval - time.Minute))
+
+		checks.markChanged(key)
+		due, _ = checks.due(key, now.Add(time.Minute))
+		Expect(due).To(BeTrue())
+
+		due, _ = checks.due(key, now.Add(time.Minute+driftResyncInterval))
+		Expect(due).To(BeTrue())
+	})
+
+	It("should reconcile the KoBuilders owning a changed object", func() {
+		r := &KoBuilderReconciler{}
+		object := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "my-ns", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "ko.feloy.dev/v1alpha1", Kind: "KoBuilder", Name: "my-ko-builder"},
+			{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "echo-abcde"},
+		}}}
+		key := types.NamespacedName{Name: "my-ko-builder", Namespace: "my-ns"}
+		Expect(r.changedOwners(handler.MapObject{Meta: object, Object: object})).To(ConsistOf(reconcile.Request{NamespacedName: key}))
+		Expect(r.driftChecks.changed).To(HaveKey(key))
+	})
+
+	It("should name the drifted objects", func() {
+		service := &unstructured.Unstructured{}
+		service.SetKind("Service")
+		service.SetName("echo-service")
+		Expect(driftedObjects([]*unstructured.Unstructured{deployment(1), service})).To(Equal("Deployment echo, Service echo-service"))
+	})
+
+	It("should only filter the status updates of the objects whose generation is maintained", func() {
+		update := func(old, new metav1.Object) bool {
+			return driftPredicate().Update(event.UpdateEvent{MetaOld: old, MetaNew: new})
+		}
+		old := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "echo", Generation: 1}}
+
+		statusOnly := old.DeepCopy()
+		statusOnly.Status.AvailableReplicas = 1
+		Expect(update(old, statusOnly)).To(BeFalse())
+
+		edited := old.DeepCopy()
+		edited.Generation = 2
+		Expect(update(old, edited)).To(BeTrue())
+
+		labeled := old.DeepCopy()
+		labeled.Labels = map[string]string{"app": "echo"}
+		Expect(update(old, labeled)).To(BeTrue())
+
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "echo"}}
+		Expect(update(configMap, configMap.DeepCopy())).To(BeTrue())
+	})
+})
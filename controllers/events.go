@@ -0,0 +1,14 @@
+package controllers
+
+// Reasons of the events emitted on KoBuilders, in addition to the reasons of the conditions
+const (
+	eventConfigMapCreated    = "ConfigMapCreated"
+	eventJobCreated          = "JobCreated"
+	eventJobDeleted          = "JobDeleted"
+	eventFailedCreate        = "FailedCreate"
+	eventTrackFailed         = "TrackFailed"
+	eventBuildLogsSaveFailed = "BuildLogsSaveFailed"
+	eventPruned              = "Pruned"
+	eventPruneFailed         = "PruneFailed"
+	eventCommitUnknown       = "CommitUnknown"
+)
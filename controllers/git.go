@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitHTTPClient is the client used to access the git servers,
+// a server not responding within its timeout not blocking the reconciliations
+var gitHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// gitCredentials are the basic auth credentials used to access a repository over http
+type gitCredentials struct {
+	Username string
+	Password string
+}
+
+// lsRemote returns the refs of the repository at repoURL, as git ls-remote does,
+// mapping the names of the refs to the commit SHA they point to.
+// http and https URLs are accessed with the git smart HTTP protocol
+func lsRemote(ctx context.Context, repoURL string, credentials *gitCredentials) (map[string]string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q for repository %s", u.Scheme, repoURL)
+	}
+	return lsRemoteHTTP(ctx, u, credentials)
+}
+
+// resolveBranch returns the commit SHA of the head of branch in the repository at repoURL
+func resolveBranch(ctx context.Context, repoURL string, branch string, credentials *gitCredentials) (string, error) {
+	refs, err := lsRemote(ctx, repoURL, credentials)
+	if err != nil {
+		return "", err
+	}
+	sha, ok := refs["refs/heads/"+branch]
+	if !ok {
+		return "", fmt.Errorf("branch %s not found in repository %s", branch, repoURL)
+	}
+	return sha, nil
+}
+
+// resolveCheckout returns the commit SHA the checkout points to in the repository at repoURL:
+// the checkout itself if it is a full commit SHA, or the commit of the HEAD, tag, branch or ref of this name,
+// annotated tags being resolved to the commit they are tagging
+func resolveCheckout(ctx context.Context, repoURL string, checkout string, credentials *gitCredentials) (string, error) {
+	if isCommitSHA(checkout) {
+		return checkout, nil
+	}
+	refs, err := lsRemote(ctx, repoURL, credentials)
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range []string{checkout, "refs/tags/" + checkout + "^{}", "refs/tags/" + checkout, "refs/heads/" + checkout} {
+		if sha, ok := refs[ref]; ok {
+			return sha, nil
+		}
+	}
+	return "", fmt.Errorf("checkout %s not found in repository %s", checkout, repoURL)
+}
+
+// isCommitSHA returns true if s is a full commit SHA
+func isCommitSHA(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func lsRemoteHTTP(ctx context.Context, u *url.URL, credentials *gitCredentials) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(u.String(), "/")+"/info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return nil, err
+	}
+	if credentials != nil {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	resp, err := gitHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to list refs of %s%s: %s", u.Host, u.Path, resp.Status)
+	}
+	return parseRefsAdvertisement(resp.Body)
+}
+
+// parseRefsAdvertisement parses the pkt-lines of a smart HTTP refs advertisement:
+// an optional "# service=" line followed by a flush packet, then "<sha> <ref>" lines,
+// the first one followed by a NUL and the capabilities of the server.
+// The commits tagged by annotated tags are given by "<tag>^{}" refs
+func parseRefsAdvertisement(r io.Reader) (map[string]string, error) {
+	reader := bufio.NewReader(r)
+	refs := map[string]string{}
+	for {
+		line, flush, err := readPktLine(reader)
+		if err == io.EOF {
+			return refs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if flush || strings.HasPrefix(line, "# service=") {
+			continue
+		}
+		if i := strings.IndexByte(line, 0); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		refs[parts[1]] = parts[0]
+	}
+}
+
+// readPktLine reads a pkt-line: 4 hexadecimal digits giving the length of the line, including these 4 digits,
+// followed by the data. A length of 0 is a flush packet
+func readPktLine(r io.Reader) (line string, flush bool, err error) {
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		err = fmt.Errorf("invalid pkt-line length %q", header)
+		return
+	}
+	if length == 0 {
+		flush = true
+		return
+	}
+	if length < 4 {
+		err = fmt.Errorf("invalid pkt-line length %d", length)
+		return
+	}
+	data := make([]byte, length-4)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return
+	}
+	line = string(data)
+	return
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Git branch resolution", func() {
+
+	var dir, bare, repoURL string
+	var server *httptest.Server
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		Expect(err).ToNot(HaveOccurred(), string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ko-operator-git")
+		Expect(err).ToNot(HaveOccurred())
+
+		work := filepath.Join(dir, "work")
+		git("init", "-q", work)
+		git("-C", work, "checkout", "-q", "-b", "main")
+		git("-C", work, "commit", "-q", "--allow-empty", "-m", "first")
+		git("-C", work, "tag", "-a", "1.0.0", "-m", "release")
+		git("-C", work, "checkout", "-q", "-b", "staging")
+		git("-C", work, "commit", "-q", "--allow-empty", "-m", "second")
+
+		bare = filepath.Join(dir, "repo.git")
+		git("clone", "-q", "--bare", work, bare)
+
+		// the repository is served by git with the smart HTTP protocol
+		gitPath, err := exec.LookPath("git")
+		Expect(err).ToNot(HaveOccurred())
+		server = httptest.NewServer(&cgi.Handler{
+			Path: gitPath,
+			Args: []string{"http-backend"},
+			Env:  []string{"GIT_PROJECT_ROOT=" + dir, "GIT_HTTP_EXPORT_ALL=1"},
+		})
+		repoURL = server.URL + "/repo.git"
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("should resolve branches with the smart HTTP protocol", func() {
+		sha, err := resolveBranch(context.Background(), repoURL, "staging", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sha).To(Equal(git("-C", bare, "rev-parse", "staging")))
+
+		refs, err := lsRemote(context.Background(), repoURL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(refs).To(HaveKeyWithValue("refs/tags/1.0.0", git("-C", bare, "rev-parse", "refs/tags/1.0.0")))
+
+		By("Reading packed refs")
+		git("-C", bare, "pack-refs", "--all")
+		sha, err = resolveBranch(context.Background(), repoURL, "main", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sha).To(Equal(git("-C", bare, "rev-parse", "main")))
+
+		By("Failing on unknown branches")
+		_, err = resolveBranch(context.Background(), repoURL, "unknown", nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should refuse the schemes other than http and https", func() {
+		_, err := resolveBranch(context.Background(), "file://"+bare, "main", nil)
+		Expect(err).To(MatchError(ContainSubstring("unsupported scheme")))
+	})
+
+	It("should resolve the commits of the checkouts", func() {
+		By("Resolving the annotated tags to the commit they are tagging")
+		Expect(resolveCheckout(context.Background(), repoURL, "1.0.0", nil)).To(Equal(git("-C", bare, "rev-parse", "1.0.0^{commit}")))
+		By("Resolving the branches and the HEAD")
+		Expect(resolveCheckout(context.Background(), repoURL, "staging", nil)).To(Equal(git("-C", bare, "rev-parse", "staging")))
+		Expect(resolveCheckout(context.Background(), repoURL, "HEAD", nil)).To(Equal(git("-C", bare, "rev-parse", "HEAD")))
+		By("Keeping the full commit SHAs")
+		sha := git("-C", bare, "rev-parse", "main")
+		Expect(resolveCheckout(context.Background(), "https://unreachable.invalid/repo.git", sha, nil)).To(Equal(sha))
+		By("Failing on unknown checkouts")
+		_, err := resolveCheckout(context.Background(), repoURL, "unknown", nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
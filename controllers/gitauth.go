@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"strings"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// gitAuthContainer is the name of the init container checking the access to the repository
+	gitAuthContainer = "git-auth"
+	// gitAuthPath is the path where the git credentials are mounted
+	gitAuthPath = "/etc/git-auth"
+	// gitAuthFSGroup is the group owning the git credentials when the pod runs as a non-root user without fsGroup
+	gitAuthFSGroup = int64(65533)
+)
+
+// applyGitAuth adds to the spec of the ko-builder pod the volume and env giving access
+// to the private repository, and an init container checking this access.
+// The https URL of the repository used by the builder is rewritten by git:
+// - ssh: to an ssh URL using the private key and known hosts of the secret,
+// - token: to an https URL containing the token of the secret
+func applyGitAuth(pod *corev1.PodSpec, repository string, gitAuth *kov1alpha1.GitAuth) {
+	host := strings.SplitN(repository, "/", 2)[0]
+
+	var env []corev1.EnvVar
+	switch gitAuth.Type {
+	case kov1alpha1.SSHGitAuth:
+		env = []corev1.EnvVar{
+			{
+				Name:  "GIT_SSH_COMMAND",
+				Value: "ssh -i " + gitAuthPath + "/" + corev1.SSHAuthPrivateKey + " -o IdentitiesOnly=yes -o UserKnownHostsFile=" + gitAuthPath + "/known_hosts",
+			},
+			{Name: "GIT_CONFIG_COUNT", Value: "1"},
+			{Name: "GIT_CONFIG_KEY_0", Value: "url.ssh://git@" + host + "/.insteadOf"},
+			{Name: "GIT_CONFIG_VALUE_0", Value: "https://" + host + "/"},
+		}
+	case kov1alpha1.TokenGitAuth:
+		env = []corev1.EnvVar{
+			secretEnvVar("GIT_TOKEN", gitAuth.SecretName, "token"),
+			{Name: "GIT_CONFIG_COUNT", Value: "1"},
+			{Name: "GIT_CONFIG_KEY_0", Value: "url.https://oauth2:$(GIT_TOKEN)@" + host + "/.insteadOf"},
+			{Name: "GIT_CONFIG_VALUE_0", Value: "https://" + host + "/"},
+		}
+	}
+
+	mode := int32(0400)
+	if runsAsNonRoot(pod.SecurityContext) {
+		// readable by the non-root user through the fsGroup of the pod, ssh refusing the keys of root readable by its group
+		mode = 0440
+		if pod.SecurityContext.FSGroup == nil {
+			pod.SecurityContext = pod.SecurityContext.DeepCopy()
+			fsGroup := gitAuthFSGroup
+			pod.SecurityContext.FSGroup = &fsGroup
+		}
+	}
+	pod.Volumes = append(pod.Volumes, corev1.Volume{
+		Name: gitAuthContainer,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  gitAuth.SecretName,
+				DefaultMode: &mode,
+			},
+		},
+	})
+	mount := corev1.VolumeMount{
+		MountPath: gitAuthPath,
+		Name:      gitAuthContainer,
+		ReadOnly:  true,
+	}
+
+	builder := &pod.Containers[0]
+	builder.Env = append(builder.Env, env...)
+	builder.VolumeMounts = append(builder.VolumeMounts, mount)
+
+	pod.InitContainers = append(pod.InitContainers, corev1.Container{
+		Name:            gitAuthContainer,
+		Image:           builder.Image,
+		ImagePullPolicy: builder.ImagePullPolicy,
+		Command:         []string{"sh", "-c", `git ls-remote "https://$REPOSITORY" HEAD > /dev/null`},
+		EnvFrom:         builder.EnvFrom,
+		Env:             env,
+		VolumeMounts:    []corev1.VolumeMount{mount},
+	})
+}
+
+// runsAsNonRoot returns true if the security context of the pod requires a non-root user
+func runsAsNonRoot(securityContext *corev1.PodSecurityContext) bool {
+	if securityContext == nil {
+		return false
+	}
+	return (securityContext.RunAsUser != nil && *securityContext.RunAsUser != 0) ||
+		(securityContext.RunAsNonRoot != nil && *securityContext.RunAsNonRoot)
+}
+
+// gitAuthFailed returns true if the git-auth init container of one of the pods has failed
+func gitAuthFailed(pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name == gitAuthContainer && status.State.Terminated != nil && status.State.Terminated.ExitCode != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Git authentication", func() {
+
+	gitAuth := &kov1alpha1.GitAuth{Type: kov1alpha1.SSHGitAuth, SecretName: "git-ssh"}
+	podSpec := func(securityContext *corev1.PodSecurityContext) *corev1.PodSpec {
+		return &corev1.PodSpec{
+			Containers:      []corev1.Container{{Name: "ko-builder"}},
+			SecurityContext: securityContext,
+		}
+	}
+
+	It("should mount the credentials readable by root only", func() {
+		pod := podSpec(nil)
+		applyGitAuth(pod, "github.com/my-org/my-repo", gitAuth)
+		Expect(*pod.Volumes[0].Secret.DefaultMode).To(Equal(int32(0400)))
+		Expect(pod.SecurityContext).To(BeNil())
+	})
+
+	It("should mount the credentials readable by the fsGroup of a non-root pod", func() {
+		user := int64(1000)
+		securityContext := &corev1.PodSecurityContext{RunAsUser: &user}
+		pod := podSpec(securityContext)
+		applyGitAuth(pod, "github.com/my-org/my-repo", gitAuth)
+		Expect(*pod.Volumes[0].Secret.DefaultMode).To(Equal(int32(0440)))
+		Expect(*pod.SecurityContext.FSGroup).To(Equal(gitAuthFSGroup))
+		Expect(securityContext.FSGroup).To(BeNil())
+
+		By("Keeping the fsGroup of the pod")
+		group := int64(2000)
+		pod = podSpec(&corev1.PodSecurityContext{RunAsUser: &user, FSGroup: &group})
+		applyGitAuth(pod, "github.com/my-org/my-repo", gitAuth)
+		Expect(*pod.SecurityContext.FSGroup).To(Equal(group))
+	})
+})
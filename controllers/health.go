@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultHealthCheckTimeout is the maximum duration to wait for the workloads to be available when not specified
+	defaultHealthCheckTimeout = 5 * time.Minute
+	// healthCheckInterval is the interval between two checks of the workloads not available yet
+	healthCheckInterval = 10 * time.Second
+)
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list
+
+// checkHealth checks the Deployments, StatefulSets, DaemonSets and Services of kobuilder: the ones of its inventory
+// in Operator apply mode, or the ones it owns in Builder apply mode, the builder setting their owner references.
+// They are read from the API server, for the workloads of the cluster not to be cached.
+// It returns a message naming the first one which is not available, or an empty message if all are available
+func (r *KoBuilderReconciler) checkHealth(ctx context.Context, kobuilder *kov1alpha1.KoBuilder) (unavailable string, err error) {
+	var workloads []runtime.Object
+	if applyMode(kobuilder) == kov1alpha1.OperatorApply {
+		workloads, unavailable, err = r.appliedWorkloads(ctx, kobuilder)
+	} else {
+		workloads, err = r.ownedWorkloads(ctx, kobuilder)
+	}
+	if err != nil || unavailable != "" {
+		return
+	}
+	for _, workload := range workloads {
+		if unavailable = workloadUnavailable(workload); unavailable != "" {
+			return
+		}
+	}
+	return
+}
+
+// newWorkload returns an empty object of the workload kind gk, or nil if the health of this kind is not checked
+func newWorkload(gk schema.GroupKind) runtime.Object {
+	switch gk {
+	case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+		return new(appsv1.Deployment)
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		return new(appsv1.StatefulSet)
+	case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
+		return new(appsv1.DaemonSet)
+	case schema.GroupKind{Group: "", Kind: "Service"}:
+		return new(corev1.Service)
+	}
+	return nil
+}
+
+// appliedWorkloads gets the workloads of the inventory of kobuilder.
+// It returns a message naming the first one which has been deleted, if any
+func (r *KoBuilderReconciler) appliedWorkloads(ctx context.Context, kobuilder *kov1alpha1.KoBuilder) (workloads []runtime.Object, deleted string, err error) {
+	for _, object := range kobuilder.Status.Applied {
+		workload := newWorkload(schema.FromAPIVersionAndKind(object.APIVersion, object.Kind).GroupKind())
+		if workload == nil {
+			continue
+		}
+		if err = r.APIReader.Get(ctx, types.NamespacedName{Name: object.Name, Namespace: object.Namespace}, workload); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, fmt.Sprintf("%s %s has been deleted", object.Kind, object.Name), nil
+			}
+			return
+		}
+		workloads = append(workloads, workload)
+	}
+	return
+}
+
+// ownedWorkloads lists the workloads of the namespace of kobuilder owned by kobuilder
+func (r *KoBuilderReconciler) ownedWorkloads(ctx context.Context, kobuilder *kov1alpha1.KoBuilder) (workloads []runtime.Object, err error) {
+	inNamespace := client.InNamespace(kobuilder.Namespace)
+	deployments, statefulSets := new(appsv1.DeploymentList), new(appsv1.StatefulSetList)
+	daemonSets, services := new(appsv1.DaemonSetList), new(corev1.ServiceList)
+	for _, list := range []runtime.Object{deployments, statefulSets, daemonSets, services} {
+		if err = r.APIReader.List(ctx, list, inNamespace); err != nil {
+			return
+		}
+	}
+	for i := range deployments.Items {
+		if ownedBy(&deployments.Items[i], kobuilder) {
+			workloads = append(workloads, &deployments.Items[i])
+		}
+	}
+	for i := range statefulSets.Items {
+		if ownedBy(&statefulSets.Items[i], kobuilder) {
+			workloads = append(workloads, &statefulSets.Items[i])
+		}
+	}
+	for i := range daemonSets.Items {
+		if ownedBy(&daemonSets.Items[i], kobuilder) {
+			workloads = append(workloads, &daemonSets.Items[i])
+		}
+	}
+	for i := range services.Items {
+		if ownedBy(&services.Items[i], kobuilder) {
+			workloads = append(workloads, &services.Items[i])
+		}
+	}
+	return
+}
+
+// workloadUnavailable returns a message naming the workload if it is not available, or an empty string
+func workloadUnavailable(workload runtime.Object) string {
+	var kind, name, msg string
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		kind, name, msg = "Deployment", w.Name, deploymentUnavailable(w)
+	case *appsv1.StatefulSet:
+		kind, name, msg = "StatefulSet", w.Name, statefulSetUnavailable(w)
+	case *appsv1.DaemonSet:
+		kind, name, msg = "DaemonSet", w.Name, daemonSetUnavailable(w)
+	case *corev1.Service:
+		kind, name, msg = "Service", w.Name, serviceUnavailable(w)
+	}
+	if msg == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s %s %s", kind, name, msg)
+}
+
+// deploymentUnavailable returns why the rollout of the deployment is not complete, or an empty string
+func deploymentUnavailable(d *appsv1.Deployment) string {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	switch {
+	case d.Status.ObservedGeneration < d.Generation:
+		return "is waiting for its spec to be observed"
+	case d.Status.UpdatedReplicas < replicas:
+		return fmt.Sprintf("has %d of %d replicas updated", d.Status.UpdatedReplicas, replicas)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return fmt.Sprintf("has %d old replicas pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < replicas:
+		return fmt.Sprintf("has %d of %d replicas available", d.Status.AvailableReplicas, replicas)
+	}
+	return ""
+}
+
+// statefulSetUnavailable returns why the rollout of the stateful set is not complete, or an empty string
+func statefulSetUnavailable(s *appsv1.StatefulSet) string {
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	switch {
+	case s.Status.ObservedGeneration < s.Generation:
+		return "is waiting for its spec to be observed"
+	case s.Status.ReadyReplicas < replicas:
+		return fmt.Sprintf("has %d of %d replicas ready", s.Status.ReadyReplicas, replicas)
+	case s.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType && s.Status.UpdateRevision != s.Status.CurrentRevision:
+		return fmt.Sprintf("has %d of %d replicas updated", s.Status.UpdatedReplicas, replicas)
+	}
+	return ""
+}
+
+// daemonSetUnavailable returns why the rollout of the daemon set is not complete, or an empty string
+func daemonSetUnavailable(d *appsv1.DaemonSet) string {
+	desired := d.Status.DesiredNumberScheduled
+	switch {
+	case d.Status.ObservedGeneration < d.Generation:
+		return "is waiting for its spec to be observed"
+	case d.Spec.UpdateStrategy.Type == appsv1.RollingUpdateDaemonSetStrategyType && d.Status.UpdatedNumberScheduled < desired:
+		return fmt.Sprintf("has %d of %d pods updated", d.Status.UpdatedNumberScheduled, desired)
+	case d.Status.NumberAvailable < desired:
+		return fmt.Sprintf("has %d of %d pods available", d.Status.NumberAvailable, desired)
+	}
+	return ""
+}
+
+// serviceUnavailable returns why the service is not available, or an empty string
+func serviceUnavailable(s *corev1.Service) string {
+	if s.Spec.Type == corev1.ServiceTypeLoadBalancer && len(s.Status.LoadBalancer.Ingress) == 0 {
+		return "is waiting for its load balancer"
+	}
+	return ""
+}
+
+func healthCheckTimeout(kobuilder *kov1alpha1.KoBuilder) time.Duration {
+	if kobuilder.Spec.HealthCheck == nil || kobuilder.Spec.HealthCheck.Timeout == nil {
+		return defaultHealthCheckTimeout
+	}
+	return kobuilder.Spec.HealthCheck.Timeout.Duration
+}
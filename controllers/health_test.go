@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Health of the applied workloads", func() {
+
+	It("should wait for the rollout of deployments", func() {
+		replicas := int32(2)
+		d := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Generation: 2},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 1},
+		}
+		Expect(deploymentUnavailable(d)).To(Equal("is waiting for its spec to be observed"))
+
+		d.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2}
+		Expect(deploymentUnavailable(d)).To(Equal("has 1 old replicas pending termination"))
+
+		d.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1}
+		Expect(deploymentUnavailable(d)).To(Equal("has 1 of 2 replicas available"))
+
+		d.Status.AvailableReplicas = 2
+		Expect(deploymentUnavailable(d)).To(BeEmpty())
+	})
+
+	It("should wait for the pods of daemon sets and the load balancers of services", func() {
+		d := &appsv1.DaemonSet{
+			Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 2},
+		}
+		Expect(daemonSetUnavailable(d)).To(Equal("has 2 of 3 pods available"))
+
+		s := &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer}}
+		Expect(serviceUnavailable(s)).To(Equal("is waiting for its load balancer"))
+		s.Spec.Type = corev1.ServiceTypeClusterIP
+		Expect(serviceUnavailable(s)).To(BeEmpty())
+	})
+
+	It("should only check the workloads owned by the KoBuilder", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns", UID: "kobuilder-uid"},
+		}
+		owner := []metav1.OwnerReference{{APIVersion: "ko.feloy.dev/v1alpha1", Kind: "KoBuilder", Name: kobuilder.Name, UID: kobuilder.UID}}
+		c := fake.NewFakeClientWithScheme(s,
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "my-ns"}},
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "my-ns", OwnerReferences: owner}},
+		)
+		r := &KoBuilderReconciler{Client: c, APIReader: c}
+
+		unavailable, err := r.checkHealth(context.Background(), kobuilder)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unavailable).To(Equal("StatefulSet db has 0 of 1 replicas ready"))
+	})
+
+	It("should check the workloads of the inventory in Operator apply mode", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns"},
+			Spec:       kov1alpha1.KoBuilderSpec{ApplyMode: kov1alpha1.OperatorApply},
+			Status: kov1alpha1.KoBuilderStatus{Applied: []kov1alpha1.AppliedObject{
+				{APIVersion: "v1", Kind: "ConfigMap", Namespace: "my-ns", Name: "echo-config"},
+				{APIVersion: "v1", Kind: "Service", Namespace: "my-ns", Name: "echo-service"},
+				{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "my-ns", Name: "echo"},
+			}},
+		}
+		replicas := int32(1)
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "my-ns"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		}
+		c := fake.NewFakeClientWithScheme(s, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "echo-service", Namespace: "my-ns"}})
+		r := &KoBuilderReconciler{Client: c, APIReader: c}
+
+		unavailable, err := r.checkHealth(context.Background(), kobuilder)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unavailable).To(Equal("Deployment echo has been deleted"))
+
+		Expect(c.Create(context.Background(), deployment)).To(Succeed())
+		unavailable, err = r.checkHealth(context.Background(), kobuilder)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unavailable).To(BeEmpty())
+	})
+})
@@ -1,7 +1,7 @@
 package controllers
 
 import (
-	"fmt"
+	"strconv"
 
 	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
 	batchv1 "k8s.io/api/batch/v1"
@@ -9,11 +9,35 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func createJob(kobuilder *kov1alpha1.KoBuilder, configName string) *batchv1.Job {
-	return &batchv1.Job{
+const (
+	// annotationCheckout is the annotation of the job containing the checkout it builds
+	annotationCheckout = "ko.feloy.dev/checkout"
+	// annotationCommit is the annotation of the job containing the commit SHA resolved by the operator from its checkout
+	annotationCommit = "ko.feloy.dev/commit"
+	// annotationConfigHash is the annotation of the job containing the hash of the configuration it builds
+	annotationConfigHash = "ko.feloy.dev/config-hash"
+	// annotationGeneration is the annotation of the job containing the generation of the KoBuilder it has been created for
+	annotationGeneration = "ko.feloy.dev/generation"
+	// annotationRecorded is the annotation of a terminated job whose run has been recorded in the status of its KoBuilder
+	annotationRecorded = "ko.feloy.dev/recorded"
+	// annotationApplied is the annotation of a completed job whose manifests have been applied by the operator
+	annotationApplied = "ko.feloy.dev/applied"
+)
+
+// DefaultBuilderImage is the ko-builder image used when not specified
+const DefaultBuilderImage = kov1alpha1.DefaultBuilderImage
+
+// createJob returns the Job of the given run of kobuilder, building checkout with the configuration of the configName ConfigMap
+func createJob(kobuilder *kov1alpha1.KoBuilder, configName string, checkout string, builderImage string, run int64) *batchv1.Job {
+	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-job", kobuilder.Name),
+			Name:      jobName(kobuilder, run),
 			Namespace: kobuilder.Namespace,
+			Labels:    runLabels(kobuilder, run, ""),
+			Annotations: map[string]string{
+				annotationCheckout:   checkout,
+				annotationGeneration: strconv.FormatInt(kobuilder.Generation, 10),
+			},
 		},
 		Spec: batchv1.JobSpec{
 			Template: corev1.PodTemplateSpec{
@@ -23,7 +47,7 @@ func createJob(kobuilder *kov1alpha1.KoBuilder, configName string) *batchv1.Job
 					Containers: []corev1.Container{
 						{
 							Name:  "ko-builder",
-							Image: "feloy/ko-builder:release-1.4.0",
+							Image: builderImage,
 							EnvFrom: []corev1.EnvFromSource{
 								{
 									ConfigMapRef: &corev1.ConfigMapEnvSource{
@@ -34,11 +58,6 @@ func createJob(kobuilder *kov1alpha1.KoBuilder, configName string) *batchv1.Job
 								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
-								{
-									MountPath: "/etc/gcloud",
-									Name:      "gcloud",
-									ReadOnly:  true,
-								},
 								{
 									MountPath: "/pod",
 									Name:      "pod-info",
@@ -48,14 +67,6 @@ func createJob(kobuilder *kov1alpha1.KoBuilder, configName string) *batchv1.Job
 						},
 					},
 					Volumes: []corev1.Volume{
-						{
-							Name: "gcloud",
-							VolumeSource: corev1.VolumeSource{
-								Secret: &corev1.SecretVolumeSource{
-									SecretName: "gcloud",
-								},
-							},
-						},
 						{
 							Name: "pod-info",
 							VolumeSource: corev1.VolumeSource{
@@ -82,4 +93,163 @@ func createJob(kobuilder *kov1alpha1.KoBuilder, configName string) *batchv1.Job
 			},
 		},
 	}
+	job.Spec.BackoffLimit = kobuilder.Spec.BackoffLimit
+	if kobuilder.Spec.Timeout != nil {
+		deadline := int64(kobuilder.Spec.Timeout.Duration.Seconds())
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	if applyMode(kobuilder) == kov1alpha1.OperatorApply {
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "MANIFESTS_CONFIGMAP",
+			Value: manifestsConfigMapName(kobuilder, run),
+		})
+	}
+	applyRegistryCredentials(&job.Spec.Template.Spec, registryCredentials(kobuilder))
+	if kobuilder.Spec.Builder != nil {
+		applyBuilderSpec(&job.Spec.Template.Spec, kobuilder.Spec.Builder)
+	}
+	if kobuilder.Spec.GitAuth != nil {
+		applyGitAuth(&job.Spec.Template.Spec, kobuilder.Spec.Repository, kobuilder.Spec.GitAuth)
+	}
+	return job
+}
+
+// registryCredentials returns the registry credentials of kobuilder,
+// the "gcloud" secret containing GCP credentials by default
+func registryCredentials(kobuilder *kov1alpha1.KoBuilder) kov1alpha1.RegistryCredentials {
+	if kobuilder.Spec.RegistryCredentials == nil {
+		return kov1alpha1.RegistryCredentials{
+			Type:       kov1alpha1.GCPCredentials,
+			SecretName: "gcloud",
+		}
+	}
+	return *kobuilder.Spec.RegistryCredentials
+}
+
+// applyRegistryCredentials adds to the spec of the ko-builder pod the volumes and env
+// giving access to the registry credentials:
+// - gcp: the secret is mounted in /etc/gcloud,
+// - dockerconfig: the secret is mounted as /etc/docker/config.json and DOCKER_CONFIG is set,
+// - basic: REGISTRY_USERNAME and REGISTRY_PASSWORD are set from the secret
+func applyRegistryCredentials(pod *corev1.PodSpec, credentials kov1alpha1.RegistryCredentials) {
+	container := &pod.Containers[0]
+	switch credentials.Type {
+	case kov1alpha1.GCPCredentials:
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			MountPath: "/etc/gcloud",
+			Name:      "gcloud",
+			ReadOnly:  true,
+		})
+		pod.Volumes = append(pod.Volumes, corev1.Volume{
+			Name: "gcloud",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: credentials.SecretName,
+				},
+			},
+		})
+	case kov1alpha1.DockerConfigCredentials:
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			MountPath: "/etc/docker",
+			Name:      "docker-config",
+			ReadOnly:  true,
+		})
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "DOCKER_CONFIG",
+			Value: "/etc/docker",
+		})
+		pod.Volumes = append(pod.Volumes, corev1.Volume{
+			Name: "docker-config",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: credentials.SecretName,
+					Items: []corev1.KeyToPath{
+						{
+							Key:  corev1.DockerConfigJsonKey,
+							Path: "config.json",
+						},
+					},
+				},
+			},
+		})
+	case kov1alpha1.BasicCredentials:
+		container.Env = append(container.Env,
+			secretEnvVar("REGISTRY_USERNAME", credentials.SecretName, "username"),
+			secretEnvVar("REGISTRY_PASSWORD", credentials.SecretName, "password"),
+		)
+	}
+}
+
+func secretEnvVar(name string, secretName string, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: secretName,
+				},
+				Key: key,
+			},
+		},
+	}
+}
+
+// applyBuilderSpec merges the settings of builder into the spec of the ko-builder pod
+func applyBuilderSpec(pod *corev1.PodSpec, builder *kov1alpha1.BuilderSpec) {
+	container := &pod.Containers[0]
+	if builder.Image != "" {
+		container.Image = builder.Image
+	}
+	container.ImagePullPolicy = builder.ImagePullPolicy
+	container.Resources = builder.Resources
+	pod.ImagePullSecrets = builder.ImagePullSecrets
+	pod.NodeSelector = builder.NodeSelector
+	pod.Tolerations = builder.Tolerations
+	pod.Affinity = builder.Affinity
+	pod.SecurityContext = builder.SecurityContext
+	pod.PriorityClassName = builder.PriorityClassName
+}
+
+// jobCondition returns the condition of the given type of the job if it is true, nil otherwise
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		if condition := &job.Status.Conditions[i]; condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return condition
+		}
+	}
+	return nil
+}
+
+// podsStarted returns true if one of pods has been scheduled and its containers have started
+func podsStarted(pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodPending && pod.Status.Phase != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// jobConditionMessage returns the message of the condition of the given type, if present on the job
+func jobConditionMessage(job *batchv1.Job, conditionType batchv1.JobConditionType) string {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return condition.Message
+		}
+	}
+	return ""
+}
+
+// jobCompletionTime returns the time the job has completed or failed, or nil if it is not terminated
+func jobCompletionTime(job *batchv1.Job) *metav1.Time {
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			t := condition.LastTransitionTime
+			return &t
+		}
+	}
+	return nil
 }
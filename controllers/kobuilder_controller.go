@@ -18,16 +18,21 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"reflect"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 
 	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
 )
@@ -37,12 +42,32 @@ type KoBuilderReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// APIReader reads from the API server the objects not cached by the manager, as the secrets
+	APIReader client.Reader
+	// RESTMapper maps the kinds of the manifests applied by the operator to their resources
+	RESTMapper meta.RESTMapper
+	// BuilderImage is the ko-builder image used when not specified by the KoBuilder
+	BuilderImage string
+	// PodLogs is used to read the logs of the builds, which are not kept if nil
+	PodLogs corev1client.PodsGetter
+	// Recorder records the events of the KoBuilders
+	Recorder record.EventRecorder
+
+	// controller watches the kinds of the applied objects, added to watchedKinds
+	controller   controller.Controller
+	watchedKinds map[schema.GroupVersionKind]bool
+	watchLock    sync.Mutex
+	// driftChecks tracks the KoBuilders whose drifts have to be checked
+	driftChecks driftChecks
 }
 
 // +kubebuilder:rbac:groups=ko.feloy.dev,resources=kobuilders,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=ko.feloy.dev,resources=kobuilders/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func (r *KoBuilderReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
 	ctx := context.Background()
@@ -53,119 +78,355 @@ func (r *KoBuilderReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, e
 		// we'll ignore not-found errors, since they can't be fixed by an immediate
 		// requeue (we'll need to wait for a new notification), and we can get them
 		// on deleted requests.
+		if apierrors.IsNotFound(err) {
+			forgetKoBuilderMetrics(req.Namespace, req.Name)
+			r.driftChecks.forget(req.NamespacedName)
+		}
 		err = client.IgnoreNotFound(err)
 		return
 	}
 	log.Info(fmt.Sprintf("kobuilder: %+v", kobuilder.Spec))
 
-	var configName string
-	if configName, err = r.applyConfig(ctx, log, kobuilder); err != nil {
+	var trackErr error
+	if result.RequeueAfter, trackErr = r.trackBranch(ctx, log, kobuilder); trackErr != nil {
+		log.Error(trackErr, "unable to resolve the head of the tracked branch")
+		r.Recorder.Eventf(kobuilder, corev1.EventTypeWarning, eventTrackFailed, "Unable to resolve the head of branch %s: %s", kobuilder.Spec.Track.Branch, trackErr)
+		// the reconciliation goes on with the last resolved head, the tracking being retried
+		// with the backoff of the failed reconciliations
+		defer func() {
+			if err == nil {
+				err = trackErr
+			}
+		}()
+	}
+
+	var checkout string
+	if checkout, err = checkoutToBuild(kobuilder); err != nil {
+		log.Info(fmt.Sprintf("Invalid rollback: %s", err))
+		r.Recorder.Event(kobuilder, corev1.EventTypeWarning, reasonInvalidRollback, err.Error())
+		err = r.setState(ctx, log, kobuilder, kov1alpha1.ErrorDeploying, reasonInvalidRollback, err.Error())
+		return
+	}
+
+	var job *batchv1.Job
+	if job, err = r.getRunJob(ctx, kobuilder); err != nil {
+		return
+	}
+
+	running := job != nil && !runRecorded(kobuilder, job)
+	if err = r.applyConfig(ctx, log, kobuilder, checkout, running); err != nil {
+		return
+	}
+
+	var requeueAfter time.Duration
+	if requeueAfter, err = r.applyKoBuilderJob(ctx, log, kobuilder, job, checkout); err != nil {
 		return
 	}
+	result.RequeueAfter = sooner(result.RequeueAfter, requeueAfter)
 
-	if err = r.applyKoBuilderJob(ctx, log, kobuilder, configName); err != nil {
+	if _, expires := failedJobTTL(kobuilder); expires {
+		// delete the failed jobs kept for the failed job TTL
+		if requeueAfter, err = r.pruneRuns(ctx, kobuilder, time.Now()); err != nil {
+			return
+		}
+		result.RequeueAfter = sooner(result.RequeueAfter, requeueAfter)
+	}
+
+	if err = r.watchInventory(kobuilder); err != nil {
+		return
+	}
+	if requeueAfter, err = r.checkDrift(ctx, log, kobuilder); err != nil {
+		log.Error(err, "unable to check the drift of the applied objects")
 		return
 	}
+	result.RequeueAfter = sooner(result.RequeueAfter, requeueAfter)
 
 	return
 }
 
-func (r *KoBuilderReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+// sooner returns the shortest of the non-zero requeue durations a and b, or zero if both are zero
+func sooner(a time.Duration, b time.Duration) time.Duration {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func (r *KoBuilderReconciler) SetupWithManager(mgr ctrl.Manager) (err error) {
+	// the applied objects are watched once found in an inventory, see watchInventory
+	r.controller, err = ctrl.NewControllerManagedBy(mgr).
 		For(&kov1alpha1.KoBuilder{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&batchv1.Job{}).
-		Complete(r)
+		Build(r)
+	return
 }
 
-func (r *KoBuilderReconciler) applyConfig(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder) (name string, err error) {
-
-	expected := createConfigMap(kobuilder)
-
-	found := new(corev1.ConfigMap)
-	err = r.Get(ctx, types.NamespacedName{Name: expected.ObjectMeta.Name, Namespace: expected.ObjectMeta.Namespace}, found)
-	if err == nil {
-		// ConfigMap found
-		if !reflect.DeepEqual(expected.Data, found.Data) {
-			// different than expected
-			//   => update the ConfigMap
-			controllerutil.SetControllerReference(kobuilder, expected, r.Scheme)
-			err = r.Update(ctx, expected)
-			if err != nil {
-				return
-			}
-			//   => set the status of kobuilder as Updated
-			r.setState(ctx, log, kobuilder, kov1alpha1.Updated)
-			name = found.Name
-			return
-		}
-
-		// do nothing
-		name = found.Name
+// applyConfig sets kobuilder as Updated when the configuration to build differs from the configuration
+// of its last run and no job is running, for a new run to be created, unless kobuilder is suspended
+func (r *KoBuilderReconciler) applyConfig(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, checkout string, running bool) (err error) {
+	state := kobuilder.Status.State
+	if running || kobuilder.Spec.Suspend || state == "" || state == kov1alpha1.Updated {
 		return
 	}
-	err = client.IgnoreNotFound(err)
-	if err != nil {
+	if expectedConfigHash(kobuilder, checkout) == kobuilder.Status.ConfigHash {
 		return
 	}
+	kobuilder.Status.Attempts = 0
+	kobuilder.Status.NextRetryTime = nil
+	return r.setState(ctx, log, kobuilder, kov1alpha1.Updated, reasonConfigUpdated, "Configuration updated, a new build is pending")
+}
 
-	// configmap not found => create it
-	controllerutil.SetControllerReference(kobuilder, expected, r.Scheme)
-
-	if err = r.Create(ctx, expected); err != nil {
-		log.Error(err, "unable to create configmap for kobuilder")
+// completedJobState returns the state of kobuilder whose last job has completed, after having applied
+// the manifests in Operator apply mode: Deployed once the workloads are available, Deploying while waiting for them,
+// with the duration after which they have to be checked again, or Unhealthy after the health check timeout
+func (r *KoBuilderReconciler) completedJobState(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, job *batchv1.Job) (state kov1alpha1.KoBuilderState, reason string, message string, recheckAfter time.Duration, err error) {
+	if applyMode(kobuilder) == kov1alpha1.OperatorApply && job.Annotations[annotationApplied] != "true" {
+		applied, applyErr := r.applyManifests(ctx, kobuilder, job)
+		if applyErr != nil {
+			log.Error(applyErr, "unable to apply the manifests")
+			return kov1alpha1.ErrorDeploying, reasonApplyFailed, applyErr.Error(), 0, nil
+		}
+		r.updateInventory(ctx, log, kobuilder, applied)
+		kobuilder.Status.AppliedRun = runOf(job.Labels)
+		// persist the inventory before marking the job as applied,
+		// the manifests being applied again if the status cannot be updated
+		if err = r.Status().Update(ctx, kobuilder); err != nil {
+			return
+		}
+		// mark the job as applied, for the manifests not to be applied again while checking the workloads
+		if job.Annotations == nil {
+			job.Annotations = map[string]string{}
+		}
+		job.Annotations[annotationApplied] = "true"
+		if err = r.Update(ctx, job); err != nil {
+			return
+		}
 	}
 
-	name = expected.Name
-
-	return
+	if timeout := healthCheckTimeout(kobuilder); timeout > 0 {
+		var unavailable string
+		if unavailable, err = r.checkHealth(ctx, kobuilder); err != nil {
+			return
+		}
+		if unavailable != "" {
+			completed := job.CreationTimestamp.Time
+			if t := jobCompletionTime(job); t != nil {
+				completed = t.Time
+			}
+			if elapsed := time.Since(completed); elapsed < timeout {
+				recheckAfter = healthCheckInterval
+				if timeout-elapsed < recheckAfter {
+					recheckAfter = timeout - elapsed
+				}
+				message = fmt.Sprintf("Images pushed and manifests applied, waiting until %s", unavailable)
+				return kov1alpha1.Deploying, reasonRolloutPending, message, recheckAfter, nil
+			}
+			message = fmt.Sprintf("%s after %s", unavailable, timeout)
+			return kov1alpha1.Unhealthy, reasonUnhealthy, message, 0, nil
+		}
+	}
+	return kov1alpha1.Deployed, reasonJobSucceeded, "Images pushed and manifests applied", 0, nil
 }
 
-func (r *KoBuilderReconciler) applyKoBuilderJob(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, configName string) (err error) {
+// applyKoBuilderJob follows the state of found, the job of the last run, and creates a new run
+// building and deploying checkout if necessary.
+// It returns the duration after which a failed build has to be retried or a pending pod checked, if any
+func (r *KoBuilderReconciler) applyKoBuilderJob(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, found *batchv1.Job, checkout string) (requeueAfter time.Duration, err error) {
 
-	expected := createJob(kobuilder, configName)
+	if found != nil && !runRecorded(kobuilder, found) {
+		// Job of the last run found, and not recorded yet
+		outdated := jobOutdated(found, kobuilder, checkout)
+		policy := supersedePolicy(kobuilder)
+		running := jobCondition(found, batchv1.JobComplete) == nil && jobCondition(found, batchv1.JobFailed) == nil
+		if (outdated || kobuilder.Spec.Suspend) && running && policy == kov1alpha1.CancelSupersededBuild {
+			log.Info("Build inputs changed or builds suspended during the build => Cancel job")
+			err = r.cancelJob(ctx, log, kobuilder, found)
+			return
+		}
 
-	found := new(batchv1.Job)
-	err = r.Get(ctx, types.NamespacedName{Name: expected.ObjectMeta.Name, Namespace: expected.ObjectMeta.Namespace}, found)
-	if err == nil {
-		deleteJob := false
-		// Job found
+		terminated, stuck := false, false
 		// Set kobuilder state depending on job status
 		var state kov1alpha1.KoBuilderState
-		if found.Status.Succeeded == 1 {
-			state = kov1alpha1.Deployed
-			deleteJob = true
-		} else if found.Status.Failed == 1 {
+		var reason, message string
+		if jobCondition(found, batchv1.JobComplete) != nil {
+			if state, reason, message, requeueAfter, err = r.completedJobState(ctx, log, kobuilder, found); err != nil {
+				return
+			}
+			if state == kov1alpha1.Deployed {
+				kobuilder.Status.Attempts = 0
+				kobuilder.Status.NextRetryTime = nil
+				if rollback := kobuilder.Status.AutoRollback; rollback != nil && autoRollbackRevision(kobuilder) != nil {
+					reason = reasonRolledBack
+					message = fmt.Sprintf("Revision %d failed, revision %d deployed again", rollback.From, rollback.To)
+				}
+			}
+			// the job is terminated once the workloads are checked
+			terminated = state != kov1alpha1.Deploying
+		} else if failed := jobCondition(found, batchv1.JobFailed); failed != nil {
 			state = kov1alpha1.ErrorDeploying
-			deleteJob = true
-		} else if found.Status.Active == 1 {
-			state = kov1alpha1.Deploying
+			reason, message = reasonJobFailed, jobConditionMessage(found, batchv1.JobFailed)
+			var pods []corev1.Pod
+			if pods, err = r.getJobPods(ctx, found); err != nil {
+				return
+			}
+			if failed.Reason == "DeadlineExceeded" {
+				reason = reasonTimedOut
+			} else if gitAuthFailed(pods) {
+				reason, message = reasonGitAuthFailed, "Unable to access the repository with the git credentials"
+			}
 		} else {
-			log.Info(fmt.Sprintf("Unknown state! job status: %+v", found.Status))
-			state = kov1alpha1.Unknown
+			var pods []corev1.Pod
+			if pods, err = r.getJobPods(ctx, found); err != nil {
+				return
+			}
+			stuckReason, stuckMessage, recheckAfter := stuckPod(pods, pendingTimeout(kobuilder), time.Now())
+			if stuckReason != "" {
+				// a stuck build is failed, its job being deleted once recorded
+				state = kov1alpha1.ErrorDeploying
+				stuck = true
+				reason, message = stuckReason, stuckMessage
+			} else if found.Status.Active > 0 && podsStarted(pods) {
+				// the job is active, possibly after the failure of previous pods
+				state = kov1alpha1.Deploying
+				reason, message = reasonJobActive, "Build in progress"
+			} else {
+				state = kov1alpha1.Pending
+				reason, message = reasonJobPending, "Waiting for the pod of the build to start"
+			}
+			requeueAfter = recheckAfter
+			if outdated && policy == kov1alpha1.QueueSupersedingBuild {
+				message = fmt.Sprintf("%s, a new build is queued for the updated spec", message)
+			}
 		}
-		err = r.setState(ctx, log, kobuilder, state)
-
-		// If job is terminated (in success or error) => delete it
-		if deleteJob {
-			r.Delete(ctx, found)
+		if state == kov1alpha1.ErrorDeploying {
+			if requeueAfter = recordFailure(kobuilder, reason, time.Now()); requeueAfter > 0 {
+				message = fmt.Sprintf("%s, retrying in %s", message, requeueAfter)
+			}
+			terminated = true
+		}
+		if err = r.recordRun(ctx, kobuilder, found, state); err != nil {
+			return
+		}
+		if terminated {
+			if err := r.saveBuildLogs(ctx, kobuilder, found); err != nil {
+				log.Error(err, "unable to save the build logs")
+				r.Recorder.Eventf(kobuilder, corev1.EventTypeWarning, eventBuildLogsSaveFailed, "Unable to save the logs of job %s: %s", found.Name, err)
+			}
+		}
+		// the status update fails on conflict if the run has already been recorded by a previous reconciliation
+		if err = r.setState(ctx, log, kobuilder, state, reason, message); err != nil {
+			return
+		}
+		if terminated {
+			observeBuild(kobuilder, state, reason)
+		}
+		if stuck {
+			// deleted again by the next reconciliation on failure
+			if err := r.deleteStuckJob(ctx, kobuilder, found); err != nil {
+				log.Error(err, "unable to delete the job of the stuck build")
+			}
+		} else if terminated {
+			// mark the job as recorded once its run is persisted in the status
+			if found.Annotations == nil {
+				found.Annotations = map[string]string{}
+			}
+			found.Annotations[annotationRecorded] = "true"
+			if err := r.Update(ctx, found); err != nil {
+				log.Error(err, "unable to mark the job as recorded")
+			}
+		}
+		switch state {
+		case kov1alpha1.Deployed:
+			r.Recorder.Eventf(kobuilder, corev1.EventTypeNormal, reason, "Build of %s succeeded: %s", found.Annotations[annotationCheckout], message)
+		case kov1alpha1.ErrorDeploying:
+			r.Recorder.Eventf(kobuilder, corev1.EventTypeWarning, reason, "Build of %s failed: %s", found.Annotations[annotationCheckout], message)
+		case kov1alpha1.Unhealthy:
+			r.Recorder.Eventf(kobuilder, corev1.EventTypeWarning, reason, "Build of %s deployed but unhealthy: %s", found.Annotations[annotationCheckout], message)
+		}
+		failed := state == kov1alpha1.Unhealthy || (state == kov1alpha1.ErrorDeploying && kobuilder.Status.NextRetryTime == nil)
+		if terminated && failed && !outdated {
+			var rolledBack bool
+			if rolledBack, err = r.autoRollback(ctx, log, kobuilder, runOf(found.Labels)); err != nil || rolledBack {
+				return
+			}
+		}
+		if terminated && outdated {
+			if policy == kov1alpha1.IgnoreSpecChange {
+				// the spec change is ignored => do not build the current configuration
+				kobuilder.Status.ConfigHash = expectedConfigHash(kobuilder, checkout)
+				err = r.Status().Update(ctx, kobuilder)
+				return
+			}
+			// the spec has changed during the build => build the current spec
+			kobuilder.Status.Attempts = 0
+			kobuilder.Status.NextRetryTime = nil
+			err = r.setState(ctx, log, kobuilder, kov1alpha1.Updated, reasonConfigUpdated, "Spec updated during the build, a new build is pending")
 		}
 		return
 	}
-	err = client.IgnoreNotFound(err)
-	if err != nil {
-		return
+
+	// Job of the last run not found or already recorded
+
+	if found != nil && jobCondition(found, batchv1.JobComplete) == nil && jobCondition(found, batchv1.JobFailed) == nil {
+		// a recorded job still running is a stuck build whose job could not be deleted
+		if err = r.deleteStuckJob(ctx, kobuilder, found); err != nil {
+			return
+		}
 	}
 
-	// Job not found
+	if kobuilder.Spec.Suspend {
+		if kobuilder.Status.State != kov1alpha1.Suspended {
+			log.Info("KoBuilder suspended => Do not create jobs")
+			if err = r.setState(ctx, log, kobuilder, kov1alpha1.Suspended, reasonSuspended, "Builds suspended"); err != nil {
+				return
+			}
+			r.Recorder.Event(kobuilder, corev1.EventTypeNormal, reasonSuspended, "Builds suspended")
+		}
+		return
+	}
+	if kobuilder.Status.State == kov1alpha1.Suspended {
+		state, reason, message := resumedState(&kobuilder.Status)
+		log.Info(fmt.Sprintf("KoBuilder resumed => %s", state))
+		if err = r.setState(ctx, log, kobuilder, state, reason, message); err != nil {
+			return
+		}
+		r.Recorder.Event(kobuilder, corev1.EventTypeNormal, reasonResumed, message)
+	}
 
-	if kobuilder.Status.State == "" || kobuilder.Status.State == kov1alpha1.Updated {
-		log.Info("Job not found and status empty or updated => Create job")
-		controllerutil.SetControllerReference(kobuilder, expected, r.Scheme)
+	if token, ok := rebuildRequested(kobuilder); ok {
+		log.Info(fmt.Sprintf("Rebuild requested with token %q", token))
+		kobuilder.Status.LastRebuildToken = token
+		kobuilder.Status.Attempts = 0
+		kobuilder.Status.NextRetryTime = nil
+		if err = r.setState(ctx, log, kobuilder, kov1alpha1.Updated, reasonRebuildRequested, "Rebuild requested, a new build is pending"); err != nil {
+			return
+		}
+		r.Recorder.Eventf(kobuilder, corev1.EventTypeNormal, reasonRebuildRequested, "Rebuild requested with token %q", token)
+	}
 
-		if err = r.Create(ctx, expected); err != nil {
-			log.Error(err, "unable to create job for kobuilder")
+	if kobuilder.Status.State == kov1alpha1.ErrorDeploying {
+		due, wait := retryDue(kobuilder, time.Now())
+		if wait > 0 {
+			requeueAfter = wait
+			return
 		}
+		if due {
+			log.Info(fmt.Sprintf("Retrying the failed build after %d attempt(s)", kobuilder.Status.Attempts))
+			kobuilder.Status.NextRetryTime = nil
+			message := fmt.Sprintf("Retrying the build failed with reason %s (retry %d of %d)",
+				kobuilder.Status.LastFailureReason, kobuilder.Status.Attempts, kobuilder.Spec.RetryPolicy.MaxRetries)
+			if err = r.setState(ctx, log, kobuilder, kov1alpha1.Updated, reasonRetrying, message); err != nil {
+				return
+			}
+			r.Recorder.Event(kobuilder, corev1.EventTypeNormal, reasonRetrying, message)
+			buildRetries.WithLabelValues(kobuilder.Namespace, kobuilder.Name).Inc()
+		}
+	}
+
+	if kobuilder.Status.State == "" || kobuilder.Status.State == kov1alpha1.Updated {
+		log.Info("No running job and status empty or updated => Create a new run")
+		err = r.createRun(ctx, log, kobuilder, checkout)
 	}
 	return
 }
@@ -9,8 +9,10 @@ import (
 	. "github.com/onsi/gomega"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var _ = Describe("KoBuilder controller", func() {
@@ -19,7 +21,7 @@ var _ = Describe("KoBuilder controller", func() {
 	const interval = time.Second * 1
 
 	BeforeEach(func() {
-		// Add any setup steps that needs to be executed before each test
+		skipWithoutControlPlane()
 	})
 
 	AfterEach(func() {
@@ -35,12 +37,12 @@ var _ = Describe("KoBuilder controller", func() {
 			}
 
 			cmKey := types.NamespacedName{
-				Name:      "my-ko-builder-config",
+				Name:      "my-ko-builder-config-1",
 				Namespace: "my-ns",
 			}
 
 			jobKey := types.NamespacedName{
-				Name:      "my-ko-builder-job",
+				Name:      "my-ko-builder-job-1",
 				Namespace: "my-ns",
 			}
 
@@ -60,6 +62,7 @@ var _ = Describe("KoBuilder controller", func() {
 
 			// Create
 			Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+			defer deleteRuns(key)
 			defer k8sClient.Delete(context.Background(), created)
 
 			By("Expecting configmap created with correct data")
@@ -80,7 +83,7 @@ var _ = Describe("KoBuilder controller", func() {
 			}, timeout, interval).Should(BeNil())
 		})
 
-		It("KoBuilder status should be Unknown", func() {
+		It("KoBuilder status should be Pending", func() {
 
 			key := types.NamespacedName{
 				Name:      "my-ko-builder",
@@ -103,12 +106,160 @@ var _ = Describe("KoBuilder controller", func() {
 
 			// Create
 			Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+			defer deleteRuns(key)
 			defer k8sClient.Delete(context.Background(), created)
 
 			Eventually(func() bool {
 				f := &kov1alpha1.KoBuilder{}
 				return k8sClient.Get(context.Background(), key, f) == nil &&
-					f.Status.State == kov1alpha1.Unknown
+					f.Status.State == kov1alpha1.Pending &&
+					conditionStatus(f, kov1alpha1.BuildingCondition) == corev1.ConditionTrue
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Job should use the builder settings", func() {
+
+			key := types.NamespacedName{
+				Name:      "my-ko-builder-settings",
+				Namespace: "my-ns",
+			}
+
+			jobKey := types.NamespacedName{
+				Name:      "my-ko-builder-settings-job-1",
+				Namespace: "my-ns",
+			}
+
+			created := &kov1alpha1.KoBuilder{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: kov1alpha1.KoBuilderSpec{
+					Registry:       "user/ko-builder",
+					ServiceAccount: "account@project.com",
+					Repository:     "github/com/test/repo",
+					Checkout:       "1.2.3",
+					ConfigPath:     "/templates",
+					Builder: &kov1alpha1.BuilderSpec{
+						Image:             "user/ko-builder:patched",
+						NodeSelector:      map[string]string{"pool": "build"},
+						PriorityClassName: "builds",
+					},
+				},
+			}
+
+			// Create
+			Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+			defer deleteRuns(key)
+			defer k8sClient.Delete(context.Background(), created)
+
+			By("Expecting job created with the builder settings")
+			Eventually(func() bool {
+				f := &batchv1.Job{}
+				if k8sClient.Get(context.Background(), jobKey, f) != nil {
+					return false
+				}
+				pod := f.Spec.Template.Spec
+				return pod.Containers[0].Image == "user/ko-builder:patched" &&
+					pod.NodeSelector["pool"] == "build" &&
+					pod.PriorityClassName == "builds"
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Job should mount the docker config registry credentials", func() {
+
+			key := types.NamespacedName{
+				Name:      "my-ko-builder-dockerconfig",
+				Namespace: "my-ns",
+			}
+
+			jobKey := types.NamespacedName{
+				Name:      "my-ko-builder-dockerconfig-job-1",
+				Namespace: "my-ns",
+			}
+
+			created := &kov1alpha1.KoBuilder{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: kov1alpha1.KoBuilderSpec{
+					Registry:   "localhost:5000",
+					Repository: "github/com/test/repo",
+					Checkout:   "1.2.3",
+					ConfigPath: "/templates",
+					RegistryCredentials: &kov1alpha1.RegistryCredentials{
+						Type:       kov1alpha1.DockerConfigCredentials,
+						SecretName: "regcred",
+					},
+				},
+			}
+
+			// Create
+			Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+			defer deleteRuns(key)
+			defer k8sClient.Delete(context.Background(), created)
+
+			By("Expecting job created with the docker config mounted")
+			Eventually(func() bool {
+				f := &batchv1.Job{}
+				if k8sClient.Get(context.Background(), jobKey, f) != nil {
+					return false
+				}
+				pod := f.Spec.Template.Spec
+				for _, volume := range pod.Volumes {
+					if volume.Secret != nil && volume.Secret.SecretName == "regcred" {
+						return len(pod.Containers[0].Env) == 1 &&
+							pod.Containers[0].Env[0].Name == "DOCKER_CONFIG"
+					}
+				}
+				return false
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Job should check the access to a private repository", func() {
+
+			key := types.NamespacedName{
+				Name:      "my-ko-builder-private",
+				Namespace: "my-ns",
+			}
+
+			jobKey := types.NamespacedName{
+				Name:      "my-ko-builder-private-job-1",
+				Namespace: "my-ns",
+			}
+
+			created := &kov1alpha1.KoBuilder{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Spec: kov1alpha1.KoBuilderSpec{
+					Registry:   "user/ko-builder",
+					Repository: "github.com/test/private",
+					GitAuth: &kov1alpha1.GitAuth{
+						Type:       kov1alpha1.TokenGitAuth,
+						SecretName: "git-token",
+					},
+					Checkout:   "1.2.3",
+					ConfigPath: "/templates",
+				},
+			}
+
+			// Create
+			Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+			defer deleteRuns(key)
+			defer k8sClient.Delete(context.Background(), created)
+
+			By("Expecting job created with the git-auth init container")
+			Eventually(func() bool {
+				f := &batchv1.Job{}
+				if k8sClient.Get(context.Background(), jobKey, f) != nil {
+					return false
+				}
+				pod := f.Spec.Template.Spec
+				return len(pod.InitContainers) == 1 &&
+					pod.InitContainers[0].Name == "git-auth"
 			}, timeout, interval).Should(BeTrue())
 		})
 
@@ -122,7 +273,7 @@ var _ = Describe("KoBuilder controller", func() {
 				}
 
 				jobKey := types.NamespacedName{
-					Name:      "my-ko-builder-job",
+					Name:      "my-ko-builder-job-1",
 					Namespace: "my-ns",
 				}
 
@@ -142,6 +293,7 @@ var _ = Describe("KoBuilder controller", func() {
 
 				// Create
 				Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+				defer deleteRuns(key)
 				defer k8sClient.Delete(context.Background(), created)
 
 				By("Expecting job created")
@@ -157,11 +309,155 @@ var _ = Describe("KoBuilder controller", func() {
 				job.Status.Succeeded = 0
 				k8sClient.Status().Update(context.Background(), job)
 
+				By("Expecting Pending while the pod is not started")
+				Eventually(func() bool {
+					f := &kov1alpha1.KoBuilder{}
+					return k8sClient.Get(context.Background(), key, f) == nil &&
+						f.Status.State == kov1alpha1.Pending
+				}, timeout, interval).Should(BeTrue())
+
+				createJobPod(job, corev1.PodRunning)
+				// touch the job to trigger a reconciliation
+				k8sClient.Get(context.Background(), jobKey, job)
+				job.Status.StartTime = &metav1.Time{Time: time.Now()}
+				k8sClient.Status().Update(context.Background(), job)
+
+				Eventually(func() bool {
+					f := &kov1alpha1.KoBuilder{}
+					return k8sClient.Get(context.Background(), key, f) == nil &&
+						f.Status.State == kov1alpha1.Deploying
+				}, timeout, interval).Should(BeTrue())
+			})
+		})
+
+		Context("A pod of job has failed and the job retries it", func() {
+
+			It("KoBuilder status should stay Deploying", func() {
+
+				key := types.NamespacedName{
+					Name:      "my-retried-ko-builder",
+					Namespace: "my-ns",
+				}
+
+				jobKey := types.NamespacedName{
+					Name:      "my-retried-ko-builder-job-1",
+					Namespace: "my-ns",
+				}
+
+				created := &kov1alpha1.KoBuilder{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      key.Name,
+						Namespace: key.Namespace,
+					},
+					Spec: kov1alpha1.KoBuilderSpec{
+						Registry:       "user/ko-builder",
+						ServiceAccount: "account@project.com",
+						Repository:     "github/com/test/repo",
+						Checkout:       "1.2.3",
+						ConfigPath:     "/templates",
+						BackoffLimit:   func(n int32) *int32 { return &n }(3),
+					},
+				}
+
+				// Create
+				Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+				defer deleteRuns(key)
+				defer k8sClient.Delete(context.Background(), created)
+
+				By("Expecting job created with the backoff limit")
+				job := &batchv1.Job{}
+				Eventually(func() bool {
+					return k8sClient.Get(context.Background(), jobKey, job) == nil &&
+						job.Spec.BackoffLimit != nil && *job.Spec.BackoffLimit == 3
+				}, timeout, interval).Should(BeTrue())
+
+				createJobPod(job, corev1.PodRunning)
+				job.Status.Active = 1
+				job.Status.Failed = 2
+				k8sClient.Status().Update(context.Background(), job)
+
 				Eventually(func() bool {
 					f := &kov1alpha1.KoBuilder{}
 					return k8sClient.Get(context.Background(), key, f) == nil &&
 						f.Status.State == kov1alpha1.Deploying
 				}, timeout, interval).Should(BeTrue())
+
+				Consistently(func() bool {
+					f := &kov1alpha1.KoBuilder{}
+					return k8sClient.Get(context.Background(), key, f) == nil &&
+						f.Status.State == kov1alpha1.Deploying &&
+						len(f.Status.History) == 0
+				}, 2*interval, interval).Should(BeTrue())
+			})
+		})
+
+		Context("The checkout is changed during the build", func() {
+
+			It("The running job should be canceled and a new job created", func() {
+
+				key := types.NamespacedName{
+					Name:      "my-canceled-ko-builder",
+					Namespace: "my-ns",
+				}
+
+				jobKey := types.NamespacedName{
+					Name:      "my-canceled-ko-builder-job-1",
+					Namespace: "my-ns",
+				}
+
+				newJobKey := types.NamespacedName{
+					Name:      "my-canceled-ko-builder-job-2",
+					Namespace: "my-ns",
+				}
+
+				created := &kov1alpha1.KoBuilder{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      key.Name,
+						Namespace: key.Namespace,
+					},
+					Spec: kov1alpha1.KoBuilderSpec{
+						Registry:       "user/ko-builder",
+						ServiceAccount: "account@project.com",
+						Repository:     "github/com/test/repo",
+						Checkout:       "1.2.3",
+						ConfigPath:     "/templates",
+					},
+				}
+
+				// Create
+				Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+				defer deleteRuns(key)
+				defer k8sClient.Delete(context.Background(), created)
+
+				By("Expecting job created")
+				job := &batchv1.Job{}
+				Eventually(func() error {
+					return k8sClient.Get(context.Background(), jobKey, job)
+				}, timeout, interval).Should(BeNil())
+
+				createJobPod(job, corev1.PodRunning)
+				job.Status.Active = 1
+				k8sClient.Status().Update(context.Background(), job)
+
+				Eventually(func() bool {
+					f := &kov1alpha1.KoBuilder{}
+					return k8sClient.Get(context.Background(), key, f) == nil &&
+						f.Status.State == kov1alpha1.Deploying
+				}, timeout, interval).Should(BeTrue())
+
+				By("Changing the checkout")
+				f := &kov1alpha1.KoBuilder{}
+				Expect(k8sClient.Get(context.Background(), key, f)).Should(Succeed())
+				f.Spec.Checkout = "1.2.4"
+				Expect(k8sClient.Update(context.Background(), f)).Should(Succeed())
+
+				By("Expecting a new job created for the new checkout")
+				Eventually(func() bool {
+					j := &batchv1.Job{}
+					return k8sClient.Get(context.Background(), newJobKey, j) == nil &&
+						j.Annotations[annotationCheckout] == "1.2.4"
+				}, timeout, interval).Should(BeTrue())
+				Expect(eventReasons(key, corev1.EventTypeNormal)).To(ContainElement(reasonBuildCanceled))
 			})
 		})
 
@@ -175,7 +471,7 @@ var _ = Describe("KoBuilder controller", func() {
 				}
 
 				jobKey := types.NamespacedName{
-					Name:      "my-ko-builder-job",
+					Name:      "my-ko-builder-job-1",
 					Namespace: "my-ns",
 				}
 
@@ -195,6 +491,7 @@ var _ = Describe("KoBuilder controller", func() {
 
 				// Create
 				Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+				defer deleteRuns(key)
 				defer k8sClient.Delete(context.Background(), created)
 
 				By("Expecting job created")
@@ -208,12 +505,22 @@ var _ = Describe("KoBuilder controller", func() {
 				job.Status.Succeeded = 1
 				job.Status.Failed = 0
 				job.Status.Active = 0
+				job.Status.Conditions = []batchv1.JobCondition{
+					{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+				}
 				k8sClient.Status().Update(context.Background(), job)
 
 				Eventually(func() bool {
 					f := &kov1alpha1.KoBuilder{}
 					return k8sClient.Get(context.Background(), key, f) == nil &&
-						f.Status.State == kov1alpha1.Deployed
+						f.Status.State == kov1alpha1.Deployed &&
+						conditionStatus(f, kov1alpha1.ReadyCondition) == corev1.ConditionTrue &&
+						conditionStatus(f, kov1alpha1.DeployedCondition) == corev1.ConditionTrue &&
+						conditionStatus(f, kov1alpha1.DegradedCondition) == corev1.ConditionFalse &&
+						f.Status.Checkout == "1.2.3" &&
+						f.Status.ObservedGeneration == f.Generation &&
+						len(f.Status.History) == 1 &&
+						f.Status.History[0].Outcome == kov1alpha1.RevisionSucceeded
 				}, timeout, interval).Should(BeTrue())
 			})
 		})
@@ -228,7 +535,7 @@ var _ = Describe("KoBuilder controller", func() {
 				}
 
 				jobKey := types.NamespacedName{
-					Name:      "my-ko-builder-job",
+					Name:      "my-ko-builder-job-1",
 					Namespace: "my-ns",
 				}
 
@@ -248,6 +555,7 @@ var _ = Describe("KoBuilder controller", func() {
 
 				// Create
 				Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+				defer deleteRuns(key)
 				defer k8sClient.Delete(context.Background(), created)
 
 				By("Expecting job created")
@@ -261,6 +569,141 @@ var _ = Describe("KoBuilder controller", func() {
 				job.Status.Failed = 1
 				job.Status.Succeeded = 0
 				job.Status.Active = 0
+				job.Status.Conditions = []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+				}
+				k8sClient.Status().Update(context.Background(), job)
+
+				Eventually(func() bool {
+					f := &kov1alpha1.KoBuilder{}
+					return k8sClient.Get(context.Background(), key, f) == nil &&
+						f.Status.State == kov1alpha1.ErrorDeploying &&
+						conditionStatus(f, kov1alpha1.ReadyCondition) == corev1.ConditionFalse &&
+						conditionStatus(f, kov1alpha1.DegradedCondition) == corev1.ConditionTrue
+				}, timeout, interval).Should(BeTrue())
+
+				By("Expecting events recorded")
+				Eventually(func() []string {
+					return eventReasons(key, corev1.EventTypeWarning)
+				}, timeout, interval).Should(ContainElement(reasonJobFailed))
+				Expect(eventReasons(key, corev1.EventTypeNormal)).To(ContainElement(eventJobCreated))
+			})
+		})
+
+		Context("The KoBuilder is suspended", func() {
+
+			It("No job should be created until it is resumed", func() {
+
+				key := types.NamespacedName{
+					Name:      "my-suspended-ko-builder",
+					Namespace: "my-ns",
+				}
+
+				jobKey := types.NamespacedName{
+					Name:      "my-suspended-ko-builder-job-1",
+					Namespace: "my-ns",
+				}
+
+				created := &kov1alpha1.KoBuilder{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      key.Name,
+						Namespace: key.Namespace,
+					},
+					Spec: kov1alpha1.KoBuilderSpec{
+						Registry:       "user/ko-builder",
+						ServiceAccount: "account@project.com",
+						Repository:     "github/com/test/repo",
+						Checkout:       "1.2.3",
+						ConfigPath:     "/templates",
+						Suspend:        true,
+					},
+				}
+
+				// Create
+				Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+				defer deleteRuns(key)
+				defer k8sClient.Delete(context.Background(), created)
+
+				By("Expecting the KoBuilder suspended")
+				Eventually(func() bool {
+					f := &kov1alpha1.KoBuilder{}
+					return k8sClient.Get(context.Background(), key, f) == nil &&
+						f.Status.State == kov1alpha1.Suspended &&
+						conditionStatus(f, kov1alpha1.SuspendedCondition) == corev1.ConditionTrue
+				}, timeout, interval).Should(BeTrue())
+				Consistently(func() bool {
+					return apierrors.IsNotFound(k8sClient.Get(context.Background(), jobKey, &batchv1.Job{}))
+				}, 2*interval, interval).Should(BeTrue())
+
+				By("Resuming the KoBuilder")
+				f := &kov1alpha1.KoBuilder{}
+				Expect(k8sClient.Get(context.Background(), key, f)).Should(Succeed())
+				f.Spec.Suspend = false
+				Expect(k8sClient.Update(context.Background(), f)).Should(Succeed())
+
+				By("Expecting job created")
+				Eventually(func() error {
+					return k8sClient.Get(context.Background(), jobKey, &batchv1.Job{})
+				}, timeout, interval).Should(BeNil())
+				Eventually(func() bool {
+					f := &kov1alpha1.KoBuilder{}
+					return k8sClient.Get(context.Background(), key, f) == nil &&
+						conditionStatus(f, kov1alpha1.SuspendedCondition) == corev1.ConditionFalse
+				}, timeout, interval).Should(BeTrue())
+				Expect(eventReasons(key, corev1.EventTypeNormal)).To(ContainElement(reasonResumed))
+			})
+		})
+
+		Context("A rebuild is requested after a failure", func() {
+
+			It("A new job should be created for the same checkout", func() {
+
+				key := types.NamespacedName{
+					Name:      "my-rebuilt-ko-builder",
+					Namespace: "my-ns",
+				}
+
+				jobKey := types.NamespacedName{
+					Name:      "my-rebuilt-ko-builder-job-1",
+					Namespace: "my-ns",
+				}
+
+				newJobKey := types.NamespacedName{
+					Name:      "my-rebuilt-ko-builder-job-2",
+					Namespace: "my-ns",
+				}
+
+				created := &kov1alpha1.KoBuilder{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      key.Name,
+						Namespace: key.Namespace,
+					},
+					Spec: kov1alpha1.KoBuilderSpec{
+						Registry:       "user/ko-builder",
+						ServiceAccount: "account@project.com",
+						Repository:     "github/com/test/repo",
+						Checkout:       "1.2.3",
+						ConfigPath:     "/templates",
+					},
+				}
+
+				// Create
+				Expect(k8sClient.Create(context.Background(), created)).Should(Succeed())
+				defer deleteRuns(key)
+				defer k8sClient.Delete(context.Background(), created)
+
+				By("Expecting job created")
+				Eventually(func() error {
+					f := &batchv1.Job{}
+					return k8sClient.Get(context.Background(), jobKey, f)
+				}, timeout, interval).Should(BeNil())
+
+				job := &batchv1.Job{}
+				k8sClient.Get(context.Background(), jobKey, job)
+				job.Status.Failed = 1
+				job.Status.Conditions = []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+				}
 				k8sClient.Status().Update(context.Background(), job)
 
 				Eventually(func() bool {
@@ -268,8 +711,71 @@ var _ = Describe("KoBuilder controller", func() {
 					return k8sClient.Get(context.Background(), key, f) == nil &&
 						f.Status.State == kov1alpha1.ErrorDeploying
 				}, timeout, interval).Should(BeTrue())
+
+				By("Annotating the KoBuilder")
+				f := &kov1alpha1.KoBuilder{}
+				Expect(k8sClient.Get(context.Background(), key, f)).Should(Succeed())
+				f.Annotations = map[string]string{annotationRebuild: "1"}
+				Expect(k8sClient.Update(context.Background(), f)).Should(Succeed())
+
+				By("Expecting the token handled and a new job created")
+				Eventually(func() bool {
+					f := &kov1alpha1.KoBuilder{}
+					j := &batchv1.Job{}
+					return k8sClient.Get(context.Background(), key, f) == nil &&
+						f.Status.LastRebuildToken == "1" &&
+						k8sClient.Get(context.Background(), newJobKey, j) == nil &&
+						j.Status.Failed == 0 &&
+						j.Annotations[annotationCheckout] == "1.2.3"
+				}, timeout, interval).Should(BeTrue())
 			})
 		})
 	})
 
 })
+
+// conditionStatus returns the status of the condition of the given type, or an empty status if not found
+func conditionStatus(kobuilder *kov1alpha1.KoBuilder, conditionType kov1alpha1.KoBuilderConditionType) corev1.ConditionStatus {
+	for _, condition := range kobuilder.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status
+		}
+	}
+	return ""
+}
+
+// eventReasons returns the reasons of the events of the given type recorded on the KoBuilder
+func eventReasons(key types.NamespacedName, eventType string) (reasons []string) {
+	events := &corev1.EventList{}
+	if err := k8sClient.List(context.Background(), events, client.InNamespace(key.Namespace)); err != nil {
+		return
+	}
+	for _, event := range events.Items {
+		if event.InvolvedObject.Kind == "KoBuilder" && event.InvolvedObject.Name == key.Name && event.Type == eventType {
+			reasons = append(reasons, event.Reason)
+		}
+	}
+	return
+}
+
+// deleteRuns deletes the Jobs and ConfigMaps created for the runs of the KoBuilder,
+// not garbage collected by the test environment
+func deleteRuns(key types.NamespacedName) {
+	k8sClient.DeleteAllOf(context.Background(), &batchv1.Job{}, client.InNamespace(key.Namespace), client.MatchingLabels{labelKoBuilder: key.Name})
+	k8sClient.DeleteAllOf(context.Background(), &corev1.ConfigMap{}, client.InNamespace(key.Namespace), client.MatchingLabels{labelKoBuilder: key.Name})
+}
+
+// createJobPod creates a pod of job in the given phase, as the job controller would do
+func createJobPod(job *batchv1.Job, phase corev1.PodPhase) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: job.Name + "-",
+			Namespace:    job.Namespace,
+			Labels:       map[string]string{"job-name": job.Name},
+		},
+		Spec: job.Spec.Template.Spec,
+	}
+	Expect(k8sClient.Create(context.Background(), pod)).Should(Succeed())
+	pod.Status.Phase = phase
+	Expect(k8sClient.Status().Update(context.Background(), pod)).Should(Succeed())
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// defaultLogsTailLines is the number of lines kept from the end of the logs when not specified
+	defaultLogsTailLines = 200
+	// defaultLogsHistoryLimit is the number of builds whose logs are kept when not specified
+	defaultLogsHistoryLimit = 3
+	// maxLogSize is the maximum size of the log of a container kept in the logs ConfigMap
+	maxLogSize = 256 << 10
+)
+
+// saveBuildLogs saves the tail of the logs of the containers of the last pod of job
+// in a ConfigMap referenced by the last revision of the history of kobuilder,
+// and deletes the logs ConfigMaps exceeding the logs history limit
+func (r *KoBuilderReconciler) saveBuildLogs(ctx context.Context, kobuilder *kov1alpha1.KoBuilder, job *batchv1.Job) (err error) {
+	history := kobuilder.Status.History
+	if r.PodLogs == nil || len(history) == 0 || logsHistoryLimit(kobuilder) == 0 {
+		return
+	}
+	revision := &history[len(history)-1]
+
+	var pods []corev1.Pod
+	if pods, err = r.getJobPods(ctx, job); err != nil || len(pods) == 0 {
+		return
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+	pod := pods[len(pods)-1]
+
+	tailLines := logsTailLines(kobuilder)
+	data := map[string]string{}
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if status.State.Terminated == nil && status.LastTerminationState.Terminated == nil {
+			continue
+		}
+		var content []byte
+		content, err = r.PodLogs.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: status.Name,
+			TailLines: &tailLines,
+		}).Context(ctx).DoRaw()
+		if err != nil {
+			return fmt.Errorf("unable to get the logs of container %s of pod %s: %v", status.Name, pod.Name, err)
+		}
+		if len(content) > maxLogSize {
+			content = content[len(content)-maxLogSize:]
+		}
+		data[status.Name] = string(content)
+	}
+	if len(data) == 0 {
+		return
+	}
+
+	logs := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-logs-%d", kobuilder.Name, revision.Revision),
+			Namespace: kobuilder.Namespace,
+			Labels:    runLabels(kobuilder, revision.Revision, componentLogs),
+		},
+		Data: data,
+	}
+	controllerutil.SetControllerReference(kobuilder, logs, r.Scheme)
+	if err = r.Create(ctx, logs); err != nil && !apierrors.IsAlreadyExists(err) {
+		return
+	}
+	revision.Logs = logs.Name
+
+	return r.pruneBuildLogs(ctx, kobuilder)
+}
+
+// pruneBuildLogs deletes the oldest logs ConfigMaps of kobuilder exceeding the logs history limit
+func (r *KoBuilderReconciler) pruneBuildLogs(ctx context.Context, kobuilder *kov1alpha1.KoBuilder) (err error) {
+	list := new(corev1.ConfigMapList)
+	if err = r.List(ctx, list, client.InNamespace(kobuilder.Namespace), client.MatchingLabels{labelKoBuilder: kobuilder.Name, labelComponent: componentLogs}); err != nil {
+		return
+	}
+	configMaps := list.Items
+	sort.Slice(configMaps, func(i, j int) bool {
+		return runOf(configMaps[i].Labels) < runOf(configMaps[j].Labels)
+	})
+	extra := len(configMaps) - int(logsHistoryLimit(kobuilder))
+	for i := 0; i < extra; i++ {
+		if err = client.IgnoreNotFound(r.Delete(ctx, &configMaps[i])); err != nil {
+			return
+		}
+		removeRevisionLogs(&kobuilder.Status, configMaps[i].Name)
+	}
+	return
+}
+
+// removeRevisionLogs removes the reference to the deleted logs ConfigMap from the history of status
+func removeRevisionLogs(status *kov1alpha1.KoBuilderStatus, name string) {
+	for i := range status.History {
+		if status.History[i].Logs == name {
+			status.History[i].Logs = ""
+		}
+	}
+}
+
+func logsTailLines(kobuilder *kov1alpha1.KoBuilder) int64 {
+	if kobuilder.Spec.Logs == nil || kobuilder.Spec.Logs.TailLines == nil {
+		return defaultLogsTailLines
+	}
+	return *kobuilder.Spec.Logs.TailLines
+}
+
+func logsHistoryLimit(kobuilder *kov1alpha1.KoBuilder) int32 {
+	if kobuilder.Spec.Logs == nil || kobuilder.Spec.Logs.HistoryLimit == nil {
+		return defaultLogsHistoryLimit
+	}
+	return *kobuilder.Spec.Logs.HistoryLimit
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// metricsNamespace is the prefix of the names of the metrics of the operator
+const metricsNamespace = "ko_operator"
+
+var (
+	buildsStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "builds_started_total",
+		Help:      "Number of builds started, by KoBuilder",
+	}, []string{"namespace", "kobuilder"})
+
+	buildsSucceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "builds_succeeded_total",
+		Help:      "Number of successful builds, by KoBuilder",
+	}, []string{"namespace", "kobuilder"})
+
+	buildsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "builds_failed_total",
+		Help:      "Number of failed builds, by KoBuilder and reason of the failure",
+	}, []string{"namespace", "kobuilder", "reason"})
+
+	buildRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_retries_total",
+		Help:      "Number of retries of failed builds, by KoBuilder",
+	}, []string{"namespace", "kobuilder"})
+
+	buildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_duration_seconds",
+		Help:      "Duration of the builds, by KoBuilder and outcome",
+		// from 15s to about 2h
+		Buckets: prometheus.ExponentialBuckets(15, 2, 10),
+	}, []string{"namespace", "kobuilder", "outcome"})
+)
+
+// failureReasons are the reasons of the failed builds, values of the reason label of builds_failed_total
+var failureReasons = []string{
+	reasonJobFailed, reasonTimedOut, reasonGitAuthFailed, reasonImagePullFailed, reasonPodPending, reasonApplyFailed, reasonUnhealthy,
+}
+
+// failureReason returns reason if it is a reason of failed builds, the generic reason of failed jobs otherwise
+func failureReason(reason string) string {
+	for _, failure := range failureReasons {
+		if reason == failure {
+			return reason
+		}
+	}
+	return reasonJobFailed
+}
+
+func init() {
+	metrics.Registry.MustRegister(buildsStarted, buildsSucceeded, buildsFailed, buildRetries, buildDuration)
+}
+
+// observeBuild records the outcome and duration of the terminated build of kobuilder,
+// whose run has just been recorded in the status
+func observeBuild(kobuilder *kov1alpha1.KoBuilder, state kov1alpha1.KoBuilderState, reason string) {
+	var outcome kov1alpha1.RevisionOutcome
+	switch state {
+	case kov1alpha1.Deployed:
+		outcome = kov1alpha1.RevisionSucceeded
+		buildsSucceeded.WithLabelValues(kobuilder.Namespace, kobuilder.Name).Inc()
+	case kov1alpha1.ErrorDeploying, kov1alpha1.Unhealthy:
+		outcome = kov1alpha1.RevisionFailed
+		buildsFailed.WithLabelValues(kobuilder.Namespace, kobuilder.Name, failureReason(reason)).Inc()
+	default:
+		return
+	}
+	status := kobuilder.Status
+	if status.StartTime != nil && status.CompletionTime != nil {
+		duration := status.CompletionTime.Sub(status.StartTime.Time)
+		buildDuration.WithLabelValues(kobuilder.Namespace, kobuilder.Name, string(outcome)).Observe(duration.Seconds())
+	}
+}
+
+// forgetKoBuilderMetrics deletes the metrics of the deleted KoBuilder, for all the reasons of failed builds
+func forgetKoBuilderMetrics(namespace string, name string) {
+	buildsStarted.DeleteLabelValues(namespace, name)
+	buildsSucceeded.DeleteLabelValues(namespace, name)
+	buildRetries.DeleteLabelValues(namespace, name)
+	for _, reason := range failureReasons {
+		buildsFailed.DeleteLabelValues(namespace, name, reason)
+	}
+	for _, outcome := range []kov1alpha1.RevisionOutcome{kov1alpha1.RevisionSucceeded, kov1alpha1.RevisionFailed} {
+		buildDuration.DeleteLabelValues(namespace, name, string(outcome))
+	}
+}
+
+var (
+	kobuildersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "kobuilders"),
+		"Number of KoBuilders, by namespace and state",
+		[]string{"namespace", "state"}, nil)
+
+	sinceLastDeployDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "seconds_since_last_successful_deploy"),
+		"Number of seconds since the completion of the last successful build of the KoBuilder",
+		[]string{"namespace", "kobuilder"}, nil)
+
+	buildAttemptsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "build_attempts"),
+		"Number of consecutive failed builds of the current configuration of the KoBuilder",
+		[]string{"namespace", "kobuilder"}, nil)
+)
+
+// KoBuilderCollector collects the metrics computed from the KoBuilders at each scrape:
+// the number of KoBuilders per state, the time since their last successful deploy
+// and the number of consecutive failed builds
+type KoBuilderCollector struct {
+	client.Reader
+}
+
+// Describe implements prometheus.Collector
+func (c *KoBuilderCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- kobuildersDesc
+	ch <- sinceLastDeployDesc
+	ch <- buildAttemptsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *KoBuilderCollector) Collect(ch chan<- prometheus.Metric) {
+	kobuilders := new(kov1alpha1.KoBuilderList)
+	if err := c.List(context.Background(), kobuilders); err != nil {
+		ch <- prometheus.NewInvalidMetric(kobuildersDesc, err)
+		return
+	}
+
+	type namespacedState struct {
+		namespace string
+		state     kov1alpha1.KoBuilderState
+	}
+	states := map[namespacedState]int{}
+	now := time.Now()
+	for i := range kobuilders.Items {
+		kobuilder := &kobuilders.Items[i]
+		states[namespacedState{kobuilder.Namespace, kobuilder.Status.State}]++
+
+		ch <- prometheus.MustNewConstMetric(buildAttemptsDesc, prometheus.GaugeValue,
+			float64(kobuilder.Status.Attempts), kobuilder.Namespace, kobuilder.Name)
+
+		if revision := lastSuccessfulRevision(kobuilder.Status.History); revision != nil {
+			ch <- prometheus.MustNewConstMetric(sinceLastDeployDesc, prometheus.GaugeValue,
+				now.Sub(revision.Time.Time).Seconds(), kobuilder.Namespace, kobuilder.Name)
+		}
+	}
+	for key, count := range states {
+		ch <- prometheus.MustNewConstMetric(kobuildersDesc, prometheus.GaugeValue,
+			float64(count), key.namespace, string(key.state))
+	}
+}
+
+// lastSuccessfulRevision returns the most recent successful revision of history, or nil if none
+func lastSuccessfulRevision(history []kov1alpha1.KoBuilderRevision) *kov1alpha1.KoBuilderRevision {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Outcome == kov1alpha1.RevisionSucceeded {
+			return &history[i]
+		}
+	}
+	return nil
+}
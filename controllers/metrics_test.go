@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("KoBuilder metrics", func() {
+
+	It("should collect the KoBuilders per state and their last successful deploy", func() {
+		s := runtime.NewScheme()
+		Expect(kov1alpha1.AddToScheme(s)).To(Succeed())
+		deployed := metav1.NewTime(time.Now().Add(-time.Hour))
+		c := fake.NewFakeClientWithScheme(s,
+			&kov1alpha1.KoBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "deployed", Namespace: "my-ns"},
+				Status: kov1alpha1.KoBuilderStatus{
+					State: kov1alpha1.Deployed,
+					History: []kov1alpha1.KoBuilderRevision{
+						{Revision: 1, Outcome: kov1alpha1.RevisionSucceeded, Time: deployed},
+						{Revision: 2, Outcome: kov1alpha1.RevisionFailed, Time: metav1.Now()},
+					},
+				},
+			},
+			&kov1alpha1.KoBuilder{
+				ObjectMeta: metav1.ObjectMeta{Name: "failed", Namespace: "my-ns"},
+				Status: kov1alpha1.KoBuilderStatus{
+					State:    kov1alpha1.ErrorDeploying,
+					Attempts: 2,
+				},
+			},
+		)
+
+		registry := prometheus.NewPedanticRegistry()
+		Expect(registry.Register(&KoBuilderCollector{Reader: c})).To(Succeed())
+		families, err := registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		values := map[string]float64{}
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				key := family.GetName()
+				for _, label := range metric.Label {
+					key += "/" + label.GetValue()
+				}
+				values[key] = metric.GetGauge().GetValue()
+			}
+		}
+		Expect(values).To(HaveKeyWithValue("ko_operator_kobuilders/my-ns/Deployed", 1.0))
+		Expect(values).To(HaveKeyWithValue("ko_operator_kobuilders/my-ns/ErrorDeploying", 1.0))
+		Expect(values).To(HaveKeyWithValue("ko_operator_build_attempts/failed/my-ns", 2.0))
+		Expect(values["ko_operator_seconds_since_last_successful_deploy/deployed/my-ns"]).To(BeNumerically("~", 3600, 60))
+		Expect(values).ToNot(HaveKey("ko_operator_seconds_since_last_successful_deploy/failed/my-ns"))
+	})
+
+	It("should forget the failed builds of a deleted KoBuilder", func() {
+		kobuilder := &kov1alpha1.KoBuilder{ObjectMeta: metav1.ObjectMeta{Name: "deleted", Namespace: "my-ns"}}
+		for _, reason := range failureReasons {
+			observeBuild(kobuilder, kov1alpha1.ErrorDeploying, reason)
+		}
+		forgetKoBuilderMetrics("my-ns", "deleted")
+		for _, reason := range failureReasons {
+			Expect(buildsFailed.DeleteLabelValues("my-ns", "deleted", reason)).To(BeFalse())
+		}
+
+		By("Observing the other reasons as failed jobs")
+		observeBuild(kobuilder, kov1alpha1.ErrorDeploying, reasonInvalidRollback)
+		Expect(buildsFailed.DeleteLabelValues("my-ns", "deleted", reasonJobFailed)).To(BeTrue())
+	})
+})
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// annotationPrune is the annotation of an applied object which, set to "disabled", protects it from pruning
+	annotationPrune = "ko.feloy.dev/prune"
+	pruneDisabled   = "disabled"
+)
+
+// updateInventory replaces the inventory of kobuilder with the applied objects, after having pruned
+// the objects of the previous inventory which are not applied anymore if pruning is enabled.
+// The objects which could not be pruned are kept in the inventory, to be pruned after the next run.
+// When pruning is disabled, the objects not applied anymore are kept in the inventory while they exist,
+// to be pruned once pruning is enabled
+func (r *KoBuilderReconciler) updateInventory(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, applied []kov1alpha1.AppliedObject) {
+	stale := staleObjects(kobuilder.Status.Applied, applied)
+	var kept []kov1alpha1.AppliedObject
+	if kobuilder.Spec.Prune {
+		var err error
+		if kept, err = r.pruneObjects(ctx, log, kobuilder, stale); err != nil {
+			log.Error(err, "unable to prune the objects removed from the manifests")
+			r.Recorder.Eventf(kobuilder, corev1.EventTypeWarning, eventPruneFailed, "Unable to prune %d object(s), kept in the inventory: %s", len(kept), err)
+		}
+	} else {
+		kept = r.existingObjects(ctx, log, kobuilder, stale)
+	}
+	kobuilder.Status.Applied = append(applied, kept...)
+}
+
+// existingObjects returns the stale objects which still exist and are owned by kobuilder.
+// The objects which cannot be read are considered as existing
+func (r *KoBuilderReconciler) existingObjects(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, stale []kov1alpha1.AppliedObject) (existing []kov1alpha1.AppliedObject) {
+	for _, object := range stale {
+		found := new(unstructured.Unstructured)
+		found.SetAPIVersion(object.APIVersion)
+		found.SetKind(object.Kind)
+		if err := r.Get(ctx, types.NamespacedName{Name: object.Name, Namespace: object.Namespace}, found); err != nil {
+			if !apierrors.IsNotFound(err) {
+				log.Error(err, fmt.Sprintf("unable to get %s %s", object.Kind, object.Name))
+				existing = append(existing, object)
+			}
+			continue
+		}
+		if ownedBy(found, kobuilder) {
+			existing = append(existing, object)
+		}
+	}
+	return
+}
+
+// staleObjects returns the objects of the previous inventory which are not in the current inventory.
+// The objects are compared by group, kind, namespace and name, ignoring the version of their API
+func staleObjects(previous []kov1alpha1.AppliedObject, current []kov1alpha1.AppliedObject) (stale []kov1alpha1.AppliedObject) {
+	key := func(object kov1alpha1.AppliedObject) string {
+		gk := schema.FromAPIVersionAndKind(object.APIVersion, object.Kind).GroupKind()
+		return fmt.Sprintf("%s/%s/%s", gk, object.Namespace, object.Name)
+	}
+	keys := map[string]bool{}
+	for _, object := range current {
+		keys[key(object)] = true
+	}
+	for _, object := range previous {
+		if !keys[key(object)] {
+			stale = append(stale, object)
+		}
+	}
+	return
+}
+
+// pruneObjects deletes the stale objects owned by kobuilder, except the ones protected by the prune annotation.
+// It returns the objects which could not be deleted, to be kept in the inventory, and the last error
+func (r *KoBuilderReconciler) pruneObjects(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, stale []kov1alpha1.AppliedObject) (kept []kov1alpha1.AppliedObject, err error) {
+	for _, object := range stale {
+		found := new(unstructured.Unstructured)
+		found.SetAPIVersion(object.APIVersion)
+		found.SetKind(object.Kind)
+		if getErr := r.Get(ctx, types.NamespacedName{Name: object.Name, Namespace: object.Namespace}, found); getErr != nil {
+			if !apierrors.IsNotFound(getErr) {
+				kept, err = append(kept, object), getErr
+			}
+			continue
+		}
+		if found.GetAnnotations()[annotationPrune] == pruneDisabled {
+			log.Info(fmt.Sprintf("%s %s is protected from pruning", object.Kind, object.Name))
+			continue
+		}
+		if !ownedBy(found, kobuilder) {
+			// adopted by another owner or recreated since applied
+			continue
+		}
+		if deleteErr := r.Delete(ctx, found, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(deleteErr) != nil {
+			kept, err = append(kept, object), deleteErr
+			continue
+		}
+		r.Recorder.Eventf(kobuilder, corev1.EventTypeNormal, eventPruned, "Pruned %s %s, removed from the manifests", object.Kind, object.Name)
+	}
+	return
+}
+
+// ownedBy returns true if kobuilder is one of the owners of object
+func ownedBy(object metav1.Object, kobuilder *kov1alpha1.KoBuilder) bool {
+	for _, owner := range object.GetOwnerReferences() {
+		if owner.UID == kobuilder.UID {
+			return true
+		}
+	}
+	return false
+}
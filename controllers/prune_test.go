@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var _ = Describe("Pruning of the applied objects", func() {
+
+	service := func(name string) kov1alpha1.AppliedObject {
+		return kov1alpha1.AppliedObject{APIVersion: "v1", Kind: "Service", Namespace: "my-ns", Name: name}
+	}
+
+	It("should find the objects removed from the inventory", func() {
+		deployment := kov1alpha1.AppliedObject{APIVersion: "apps/v1beta2", Kind: "Deployment", Namespace: "my-ns", Name: "echo"}
+		upgraded := deployment
+		upgraded.APIVersion = "apps/v1"
+
+		Expect(staleObjects(
+			[]kov1alpha1.AppliedObject{deployment, service("echo"), service("old")},
+			[]kov1alpha1.AppliedObject{upgraded, service("echo")},
+		)).To(ConsistOf(service("old")))
+	})
+
+	It("should only delete the unprotected objects owned by the KoBuilder", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns", UID: "kobuilder-uid"},
+		}
+		owner := []metav1.OwnerReference{{APIVersion: "ko.feloy.dev/v1alpha1", Kind: "KoBuilder", Name: kobuilder.Name, UID: kobuilder.UID}}
+		r := &KoBuilderReconciler{
+			Client: fake.NewFakeClientWithScheme(s,
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "owned", Namespace: "my-ns", OwnerReferences: owner}},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "protected", Namespace: "my-ns", OwnerReferences: owner,
+					Annotations: map[string]string{annotationPrune: pruneDisabled}}},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "not-owned", Namespace: "my-ns"}},
+			),
+			Recorder: record.NewFakeRecorder(10),
+		}
+
+		kept, err := r.pruneObjects(context.Background(), zap.Logger(true), kobuilder, []kov1alpha1.AppliedObject{
+			service("owned"), service("protected"), service("not-owned"), service("deleted"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(kept).To(BeEmpty())
+
+		get := func(name string) error {
+			return r.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "my-ns"}, &corev1.Service{})
+		}
+		Expect(apierrors.IsNotFound(get("owned"))).To(BeTrue())
+		Expect(get("protected")).To(Succeed())
+		Expect(get("not-owned")).To(Succeed())
+	})
+
+	It("should keep the objects removed from the manifests in the inventory until pruning is enabled", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns", UID: "kobuilder-uid"},
+			Status:     kov1alpha1.KoBuilderStatus{Applied: []kov1alpha1.AppliedObject{service("echo"), service("old"), service("deleted")}},
+		}
+		owner := []metav1.OwnerReference{{APIVersion: "ko.feloy.dev/v1alpha1", Kind: "KoBuilder", Name: kobuilder.Name, UID: kobuilder.UID}}
+		r := &KoBuilderReconciler{
+			Client: fake.NewFakeClientWithScheme(s,
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "my-ns", OwnerReferences: owner}},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "my-ns", OwnerReferences: owner}},
+			),
+			Recorder: record.NewFakeRecorder(10),
+		}
+
+		By("Keeping the existing objects while pruning is disabled")
+		r.updateInventory(context.Background(), zap.Logger(true), kobuilder, []kov1alpha1.AppliedObject{service("echo")})
+		Expect(kobuilder.Status.Applied).To(ConsistOf(service("echo"), service("old")))
+
+		By("Pruning them once pruning is enabled")
+		kobuilder.Spec.Prune = true
+		r.updateInventory(context.Background(), zap.Logger(true), kobuilder, []kov1alpha1.AppliedObject{service("echo")})
+		Expect(kobuilder.Status.Applied).To(ConsistOf(service("echo")))
+		err := r.Get(context.Background(), types.NamespacedName{Name: "old", Namespace: "my-ns"}, &corev1.Service{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// pushWebhookPath is the path of the push webhooks endpoint
+	pushWebhookPath = "/push"
+	// maxPushPayloadSize is the maximum size of a push webhook payload
+	maxPushPayloadSize = 5 << 20
+	// pushWebhookSecretKey is the key of the secret containing the secret of the push webhooks
+	pushWebhookSecretKey = "secret"
+	// pushReadTimeout is the maximum duration to read a push webhook request, headers included
+	pushReadTimeout = 30 * time.Second
+	// pushWriteTimeout is the maximum duration to handle a push webhook request and write the response
+	pushWriteTimeout = time.Minute
+)
+
+// PushReceiver receives the push webhooks sent by GitHub, GitLab or Gitea
+// and records the pushed commit in the status of the KoBuilders tracking the pushed branch,
+// which triggers their reconciliation
+type PushReceiver struct {
+	client.Client
+	// APIReader reads the webhook secrets from the API server, for the secrets of the cluster not to be cached
+	APIReader client.Reader
+	Log       logr.Logger
+	// Addr is the address the push webhooks endpoint binds to
+	Addr string
+}
+
+// pushEvent contains the fields of the payloads of GitHub, GitLab and Gitea push events used by the receiver
+type pushEvent struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		HTMLURL  string `json:"html_url"`
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+	} `json:"repository"`
+	Project struct {
+		WebURL     string `json:"web_url"`
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+	} `json:"project"`
+}
+
+// repositories returns the normalized repositories of the event
+func (e *pushEvent) repositories() (repositories []string) {
+	for _, u := range []string{e.Repository.HTMLURL, e.Repository.CloneURL, e.Repository.SSHURL, e.Project.WebURL, e.Project.GitHTTPURL, e.Project.GitSSHURL} {
+		if u != "" {
+			repositories = append(repositories, normalizeRepository(u))
+		}
+	}
+	return
+}
+
+// normalizeRepository returns the repository as host/path, from an https or ssh URL or a repository path
+func normalizeRepository(repository string) string {
+	if i := strings.Index(repository, "://"); i >= 0 {
+		repository = repository[i+3:]
+	} else if i := strings.Index(repository, ":"); i >= 0 && strings.Contains(repository[:i], "@") {
+		// scp-like ssh URL, as git@github.com:user/repo.git
+		repository = repository[:i] + "/" + repository[i+1:]
+	}
+	if i := strings.Index(repository, "@"); i >= 0 && i < strings.Index(repository+"/", "/") {
+		repository = repository[i+1:]
+	}
+	repository = strings.TrimSuffix(strings.TrimSuffix(repository, "/"), ".git")
+	return strings.ToLower(repository)
+}
+
+// Start implements manager.Runnable
+func (p *PushReceiver) Start(stop <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle(pushWebhookPath, p)
+	server := &http.Server{
+		Addr:              p.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: pushReadTimeout,
+		ReadTimeout:       pushReadTimeout,
+		WriteTimeout:      pushWriteTimeout,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		p.Log.Info("starting push webhooks receiver", "addr", p.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+
+	select {
+	case <-stop:
+		return server.Shutdown(context.Background())
+	case err := <-errs:
+		return err
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the webhooks being received by all the replicas
+func (p *PushReceiver) NeedLeaderElection() bool {
+	return false
+}
+
+func (p *PushReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := context.Background()
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isPushEvent(req.Header) {
+		// ping and other events are accepted and ignored
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxPushPayloadSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	event := new(pushEvent)
+	if err := json.Unmarshal(body, event); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %s", err), http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(event.Ref, "refs/heads/") || strings.Trim(event.After, "0") == "" {
+		// tags and deleted branches are ignored
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log := p.Log.WithValues("ref", event.Ref, "commit", event.After)
+
+	kobuilders := new(kov1alpha1.KoBuilderList)
+	if err := p.List(ctx, kobuilders); err != nil {
+		log.Error(err, "unable to list kobuilders")
+		http.Error(w, "unable to list kobuilders", http.StatusInternalServerError)
+		return
+	}
+
+	matching, triggered := 0, 0
+	for i := range kobuilders.Items {
+		kobuilder := &kobuilders.Items[i]
+		if !pushMatches(kobuilder, event) {
+			continue
+		}
+		matching++
+		key := types.NamespacedName{Name: kobuilder.Name, Namespace: kobuilder.Namespace}
+
+		secret, err := p.getWebhookSecret(ctx, kobuilder)
+		if err != nil {
+			log.Error(err, "unable to get the webhook secret", "kobuilder", key)
+			continue
+		}
+		if !verifyPushSignature(req.Header, body, secret) {
+			log.Info("invalid signature", "kobuilder", key)
+			continue
+		}
+
+		if err := p.recordPush(ctx, key, event.After); err != nil {
+			log.Error(err, "unable to record the pushed commit", "kobuilder", key)
+			http.Error(w, "unable to record the pushed commit", http.StatusInternalServerError)
+			return
+		}
+		log.Info("pushed commit recorded", "kobuilder", key)
+		triggered++
+	}
+
+	if matching > 0 && triggered == 0 {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprintf(w, "%d kobuilder(s) triggered\n", triggered)
+}
+
+// isPushEvent returns true if the headers are the ones of a push event of GitHub, GitLab or Gitea
+func isPushEvent(header http.Header) bool {
+	return header.Get("X-GitHub-Event") == "push" ||
+		header.Get("X-Gitea-Event") == "push" ||
+		header.Get("X-Gitlab-Event") == "Push Hook"
+}
+
+// pushMatches returns true if kobuilder accepts push webhooks and tracks the pushed branch of the pushed repository
+func pushMatches(kobuilder *kov1alpha1.KoBuilder, event *pushEvent) bool {
+	track := kobuilder.Spec.Track
+	if track == nil || track.WebhookSecretName == "" || event.Ref != "refs/heads/"+track.Branch {
+		return false
+	}
+	repository := normalizeRepository(kobuilder.Spec.Repository)
+	for _, r := range event.repositories() {
+		if r == repository {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PushReceiver) getWebhookSecret(ctx context.Context, kobuilder *kov1alpha1.KoBuilder) ([]byte, error) {
+	secret := new(corev1.Secret)
+	if err := p.APIReader.Get(ctx, types.NamespacedName{Name: kobuilder.Spec.Track.WebhookSecretName, Namespace: kobuilder.Namespace}, secret); err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[pushWebhookSecretKey]
+	if !ok || len(value) == 0 {
+		return nil, fmt.Errorf("key %s not found in secret %s", pushWebhookSecretKey, secret.Name)
+	}
+	return value, nil
+}
+
+// verifyPushSignature verifies the signature of the payload with secret:
+// the HMAC of the payload in the X-Hub-Signature-256 or X-Hub-Signature headers for GitHub,
+// the HMAC of the payload in the X-Gitea-Signature header for Gitea,
+// the secret itself in the X-Gitlab-Token header for GitLab
+func verifyPushSignature(header http.Header, payload []byte, secret []byte) bool {
+	if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+		return verifyHMAC(sha256.New, strings.TrimPrefix(signature, "sha256="), payload, secret)
+	}
+	if signature := header.Get("X-Hub-Signature"); signature != "" {
+		return verifyHMAC(sha1.New, strings.TrimPrefix(signature, "sha1="), payload, secret)
+	}
+	if signature := header.Get("X-Gitea-Signature"); signature != "" {
+		return verifyHMAC(sha256.New, signature, payload, secret)
+	}
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), secret) == 1
+	}
+	return false
+}
+
+func verifyHMAC(h func() hash.Hash, signature string, payload []byte, secret []byte) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// recordPush records the pushed commit as the head of the tracked branch in the status of the kobuilder
+func (p *PushReceiver) recordPush(ctx context.Context, key types.NamespacedName, commit string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		kobuilder := new(kov1alpha1.KoBuilder)
+		if err := p.Get(ctx, key, kobuilder); err != nil {
+			return err
+		}
+		now := metav1.Now()
+		kobuilder.Status.TrackedBranch = kobuilder.Spec.Track.Branch
+		kobuilder.Status.TrackedCommit = commit
+		kobuilder.Status.LastTrackTime = &now
+		return p.Status().Update(ctx, kobuilder)
+	})
+}
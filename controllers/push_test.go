@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Push webhooks", func() {
+
+	It("should normalize repositories", func() {
+		for _, repository := range []string{
+			"github.com/feloy/kopond",
+			"https://github.com/feloy/kopond",
+			"https://github.com/feloy/kopond.git",
+			"git@github.com:feloy/kopond.git",
+			"ssh://git@github.com/feloy/kopond.git",
+			"https://GitHub.com/feloy/kopond/",
+		} {
+			Expect(normalizeRepository(repository)).To(Equal("github.com/feloy/kopond"), repository)
+		}
+	})
+
+	It("should match the KoBuilders tracking the pushed branch", func() {
+		kobuilder := &kov1alpha1.KoBuilder{
+			Spec: kov1alpha1.KoBuilderSpec{
+				Repository: "github.com/feloy/kopond",
+				Track: &kov1alpha1.TrackSpec{
+					Branch:            "main",
+					WebhookSecretName: "push-secret",
+				},
+			},
+		}
+		event := &pushEvent{Ref: "refs/heads/main"}
+		event.Repository.CloneURL = "https://github.com/feloy/kopond.git"
+		Expect(pushMatches(kobuilder, event)).To(BeTrue())
+
+		event.Ref = "refs/heads/staging"
+		Expect(pushMatches(kobuilder, event)).To(BeFalse())
+
+		event.Ref = "refs/heads/main"
+		kobuilder.Spec.Track.WebhookSecretName = ""
+		Expect(pushMatches(kobuilder, event)).To(BeFalse())
+	})
+
+	It("should verify the signatures", func() {
+		payload := []byte(`{"ref":"refs/heads/main"}`)
+		secret := []byte("my-secret")
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+		signature := hex.EncodeToString(mac.Sum(nil))
+
+		github := http.Header{}
+		github.Set("X-Hub-Signature-256", "sha256="+signature)
+		Expect(verifyPushSignature(github, payload, secret)).To(BeTrue())
+		Expect(verifyPushSignature(github, payload, []byte("other"))).To(BeFalse())
+
+		gitea := http.Header{}
+		gitea.Set("X-Gitea-Signature", signature)
+		Expect(verifyPushSignature(gitea, payload, secret)).To(BeTrue())
+
+		gitlab := http.Header{}
+		gitlab.Set("X-Gitlab-Token", "my-secret")
+		Expect(verifyPushSignature(gitlab, payload, secret)).To(BeTrue())
+
+		Expect(verifyPushSignature(http.Header{}, payload, secret)).To(BeFalse())
+	})
+})
@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+)
+
+// annotationRebuild is the annotation of a KoBuilder whose changes start a new build of the same checkout
+const annotationRebuild = "ko.feloy.dev/rebuild"
+
+// rebuildRequested returns the value of the rebuild annotation of kobuilder
+// and true if this value has not been handled yet
+func rebuildRequested(kobuilder *kov1alpha1.KoBuilder) (token string, ok bool) {
+	token = kobuilder.Annotations[annotationRebuild]
+	ok = token != "" && token != kobuilder.Status.LastRebuildToken
+	return
+}
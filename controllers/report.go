@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// buildReport is the information reported by the ko-builder container
+// in its termination message, as "key=value" lines
+type buildReport struct {
+	// Commit is the commit SHA checked out by the builder
+	Commit string
+	// Images are the references by digest of the images pushed by the builder
+	Images []string
+}
+
+func parseBuildReport(message string) (report buildReport) {
+	scanner := bufio.NewScanner(strings.NewReader(message))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "commit":
+			report.Commit = parts[1]
+		case "image":
+			report.Images = append(report.Images, parts[1])
+		}
+	}
+	return
+}
+
+// getJobPods returns the pods created for the job. They are listed from the API server,
+// for the pods of the cluster not to be cached
+func (r *KoBuilderReconciler) getJobPods(ctx context.Context, job *batchv1.Job) (pods []corev1.Pod, err error) {
+	list := new(corev1.PodList)
+	if err = r.APIReader.List(ctx, list, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return
+	}
+	pods = list.Items
+	return
+}
+
+// getBuildReport returns the report of the ko-builder container of one of the pods
+func getBuildReport(pods []corev1.Pod) (report buildReport) {
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != "ko-builder" || status.State.Terminated == nil {
+				continue
+			}
+			report = parseBuildReport(status.State.Terminated.Message)
+			if status.State.Terminated.ExitCode == 0 {
+				return
+			}
+		}
+	}
+	return
+}
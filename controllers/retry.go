@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// defaultInitialBackoff is the delay before the first retry of a failed build when not specified
+	defaultInitialBackoff = 30 * time.Second
+	// defaultMaxBackoff is the maximum delay between two retries of a failed build when not specified
+	defaultMaxBackoff = 10 * time.Minute
+)
+
+// recordFailure counts the failed build in the status of kobuilder and,
+// if the retry policy allows it, schedules the next retry.
+// It returns the duration after which the build has to be retried, or 0 if it must not be retried
+func recordFailure(kobuilder *kov1alpha1.KoBuilder, reason string, now time.Time) (retryAfter time.Duration) {
+	status := &kobuilder.Status
+	status.Attempts++
+	status.LastFailureReason = reason
+	status.NextRetryTime = nil
+
+	policy := kobuilder.Spec.RetryPolicy
+	if policy == nil || status.Attempts > policy.MaxRetries {
+		return
+	}
+	retryAfter = retryBackoff(policy, status.Attempts)
+	next := metav1.NewTime(now.Add(retryAfter))
+	status.NextRetryTime = &next
+	return
+}
+
+// retryBackoff returns the delay before the retry following the given number of failed attempts:
+// the initial backoff, doubled for each attempt, up to the max backoff
+func retryBackoff(policy *kov1alpha1.RetryPolicy, attempts int32) time.Duration {
+	backoff, limit := defaultInitialBackoff, defaultMaxBackoff
+	if policy.InitialBackoff != nil {
+		backoff = policy.InitialBackoff.Duration
+	}
+	if policy.MaxBackoff != nil {
+		limit = policy.MaxBackoff.Duration
+	}
+	for i := int32(1); i < attempts && backoff < limit; i++ {
+		backoff *= 2
+	}
+	if backoff > limit {
+		backoff = limit
+	}
+	return backoff
+}
+
+// retryDue returns true if a retry of the failed build of kobuilder is scheduled and due,
+// and the remaining duration before the retry if it is scheduled but not due yet
+func retryDue(kobuilder *kov1alpha1.KoBuilder, now time.Time) (due bool, wait time.Duration) {
+	next := kobuilder.Status.NextRetryTime
+	if next == nil || kobuilder.Spec.RetryPolicy == nil {
+		return
+	}
+	if wait = next.Time.Sub(now); wait <= 0 {
+		due, wait = true, 0
+	}
+	return
+}
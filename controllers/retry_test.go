@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Retry of failed builds", func() {
+
+	It("should double the backoff up to the max backoff", func() {
+		policy := &kov1alpha1.RetryPolicy{
+			MaxRetries:     10,
+			InitialBackoff: &metav1.Duration{Duration: 10 * time.Second},
+			MaxBackoff:     &metav1.Duration{Duration: time.Minute},
+		}
+		Expect(retryBackoff(policy, 1)).To(Equal(10 * time.Second))
+		Expect(retryBackoff(policy, 2)).To(Equal(20 * time.Second))
+		Expect(retryBackoff(policy, 3)).To(Equal(40 * time.Second))
+		Expect(retryBackoff(policy, 4)).To(Equal(time.Minute))
+		Expect(retryBackoff(policy, 100)).To(Equal(time.Minute))
+	})
+
+	It("should schedule retries until the max retries", func() {
+		now := time.Now()
+		kobuilder := &kov1alpha1.KoBuilder{
+			Spec: kov1alpha1.KoBuilderSpec{
+				RetryPolicy: &kov1alpha1.RetryPolicy{MaxRetries: 2},
+			},
+		}
+
+		Expect(recordFailure(kobuilder, reasonJobFailed, now)).To(Equal(defaultInitialBackoff))
+		Expect(kobuilder.Status.Attempts).To(BeEquivalentTo(1))
+		Expect(kobuilder.Status.LastFailureReason).To(Equal(reasonJobFailed))
+
+		due, wait := retryDue(kobuilder, now)
+		Expect(due).To(BeFalse())
+		Expect(wait).To(Equal(defaultInitialBackoff))
+		due, _ = retryDue(kobuilder, now.Add(defaultInitialBackoff))
+		Expect(due).To(BeTrue())
+
+		Expect(recordFailure(kobuilder, reasonGitAuthFailed, now)).To(Equal(2 * defaultInitialBackoff))
+		Expect(recordFailure(kobuilder, reasonJobFailed, now)).To(BeZero())
+		Expect(kobuilder.Status.Attempts).To(BeEquivalentTo(3))
+		Expect(kobuilder.Status.NextRetryTime).To(BeNil())
+	})
+
+	It("should not retry without retry policy", func() {
+		kobuilder := &kov1alpha1.KoBuilder{}
+		Expect(recordFailure(kobuilder, reasonJobFailed, time.Now())).To(BeZero())
+		due, _ := retryDue(kobuilder, time.Now())
+		Expect(due).To(BeFalse())
+	})
+})
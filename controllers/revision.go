@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"fmt"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+)
+
+// defaultRevisionHistoryLimit is the number of revisions kept in the history when not specified
+const defaultRevisionHistoryLimit = 10
+
+// checkoutToBuild returns the checkout to build for kobuilder: the commit of the revision
+// to rollback to if any, which must be known, the commit of the revision automatically rolled back to if the rollback is active,
+// the head of the tracked branch if any, or the checkout of the spec
+func checkoutToBuild(kobuilder *kov1alpha1.KoBuilder) (checkout string, err error) {
+	if kobuilder.Spec.RollbackTo == nil {
+		if revision := autoRollbackRevision(kobuilder); revision != nil && revision.Commit != "" {
+			return revision.Commit, nil
+		}
+		return desiredCheckout(kobuilder), nil
+	}
+	revision := findRevision(kobuilder.Status.History, *kobuilder.Spec.RollbackTo)
+	if revision == nil || revision.Outcome != kov1alpha1.RevisionSucceeded {
+		err = fmt.Errorf("revision %d is not a successful revision of the history", *kobuilder.Spec.RollbackTo)
+		return
+	}
+	if revision.Commit == "" {
+		err = fmt.Errorf("the commit of revision %d is unknown", *kobuilder.Spec.RollbackTo)
+		return
+	}
+	return revision.Commit, nil
+}
+
+// desiredCheckout returns the checkout requested by the spec of kobuilder:
+// the head of the tracked branch if any, or the checkout of the spec
+func desiredCheckout(kobuilder *kov1alpha1.KoBuilder) string {
+	if kobuilder.Spec.Track != nil && kobuilder.Status.TrackedCommit != "" {
+		return kobuilder.Status.TrackedCommit
+	}
+	return kobuilder.Spec.Checkout
+}
+
+func findRevision(history []kov1alpha1.KoBuilderRevision, number int64) *kov1alpha1.KoBuilderRevision {
+	for i := range history {
+		if history[i].Revision == number {
+			return &history[i]
+		}
+	}
+	return nil
+}
+
+// appendRevision appends revision to the history of status, numbering it if not numbered by its run,
+// keeping at most limit revisions
+func appendRevision(status *kov1alpha1.KoBuilderStatus, revision kov1alpha1.KoBuilderRevision, limit int32) {
+	if revision.Revision == 0 {
+		revision.Revision = 1
+		if n := len(status.History); n > 0 {
+			revision.Revision = status.History[n-1].Revision + 1
+		}
+	}
+	status.History = append(status.History, revision)
+	if extra := len(status.History) - int(limit); extra > 0 {
+		status.History = status.History[extra:]
+	}
+}
+
+// revisionHistoryLimit returns the number of revisions to keep in the history of kobuilder,
+// the last revision being always kept for its run to be known as recorded
+func revisionHistoryLimit(kobuilder *kov1alpha1.KoBuilder) int32 {
+	if kobuilder.Spec.RevisionHistoryLimit == nil {
+		return defaultRevisionHistoryLimit
+	}
+	if *kobuilder.Spec.RevisionHistoryLimit < 1 {
+		return 1
+	}
+	return *kobuilder.Spec.RevisionHistoryLimit
+}
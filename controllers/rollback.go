@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// autoRollbackRevision returns the revision automatically rolled back to, if the rollback is still active:
+// the spec of kobuilder and the checkout it requests have not changed since the failure
+func autoRollbackRevision(kobuilder *kov1alpha1.KoBuilder) *kov1alpha1.KoBuilderRevision {
+	rollback := kobuilder.Status.AutoRollback
+	if rollback == nil || rollback.Generation != kobuilder.Generation || rollback.Checkout != desiredCheckout(kobuilder) {
+		return nil
+	}
+	return findRevision(kobuilder.Status.History, rollback.To)
+}
+
+// autoRollback deploys again the last successful revision of kobuilder after the failure of the revision
+// of the given run, if automatic rollbacks are enabled, no rollback is already active and the commit
+// of the last successful revision is known.
+// It returns true if a new run has to be created for the rollback
+func (r *KoBuilderReconciler) autoRollback(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, run int64) (rolledBack bool, err error) {
+	if !kobuilder.Spec.AutoRollback || kobuilder.Spec.RollbackTo != nil || autoRollbackRevision(kobuilder) != nil {
+		return
+	}
+	failed := findRevision(kobuilder.Status.History, run)
+	good := lastSuccessfulRevision(kobuilder.Status.History)
+	if failed == nil || good == nil {
+		return
+	}
+	if good.Commit == "" {
+		message := fmt.Sprintf("Revision %d (%s) failed, not rolling back to revision %d (%s) whose commit is unknown",
+			failed.Revision, failed.Checkout, good.Revision, good.Checkout)
+		log.Info(message)
+		r.Recorder.Event(kobuilder, corev1.EventTypeWarning, reasonAutoRollback, message)
+		return
+	}
+
+	failed.RolledBack = true
+	kobuilder.Status.AutoRollback = &kov1alpha1.AutoRollbackStatus{
+		From:       failed.Revision,
+		To:         good.Revision,
+		Checkout:   desiredCheckout(kobuilder),
+		Generation: kobuilder.Generation,
+	}
+	kobuilder.Status.Attempts = 0
+	kobuilder.Status.NextRetryTime = nil
+	message := fmt.Sprintf("Revision %d (%s) failed, rolling back to revision %d (%s)", failed.Revision, failed.Checkout, good.Revision, good.Checkout)
+	log.Info(message)
+	if err = r.setState(ctx, log, kobuilder, kov1alpha1.Updated, reasonAutoRollback, message); err != nil {
+		return
+	}
+	r.Recorder.Event(kobuilder, corev1.EventTypeWarning, reasonAutoRollback, message)
+	return true, nil
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var _ = Describe("Automatic rollback", func() {
+
+	It("should deploy again the last successful revision until the spec changes", func() {
+		s := runtime.NewScheme()
+		Expect(kov1alpha1.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns", Generation: 3},
+			Spec: kov1alpha1.KoBuilderSpec{
+				Checkout:     "2.0.0",
+				AutoRollback: true,
+			},
+			Status: kov1alpha1.KoBuilderStatus{
+				Run: 2,
+				History: []kov1alpha1.KoBuilderRevision{
+					{Revision: 1, Checkout: "1.0.0", Commit: "0123456", Outcome: kov1alpha1.RevisionSucceeded},
+					{Revision: 2, Checkout: "2.0.0", Outcome: kov1alpha1.RevisionFailed},
+				},
+			},
+		}
+		recorder := record.NewFakeRecorder(10)
+		r := &KoBuilderReconciler{
+			Client:   fake.NewFakeClientWithScheme(s, kobuilder.DeepCopy()),
+			Recorder: recorder,
+		}
+
+		rolledBack, err := r.autoRollback(context.Background(), zap.Logger(true), kobuilder, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rolledBack).To(BeTrue())
+		Expect(kobuilder.Status.State).To(Equal(kov1alpha1.Updated))
+		Expect(kobuilder.Status.History[1].RolledBack).To(BeTrue())
+		Expect(kobuilder.Status.AutoRollback).To(Equal(&kov1alpha1.AutoRollbackStatus{From: 2, To: 1, Checkout: "2.0.0", Generation: 3}))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning AutoRollback")))
+		Expect(checkoutToBuild(kobuilder)).To(Equal("0123456"))
+
+		By("Not rolling back the failed rollback")
+		rolledBack, err = r.autoRollback(context.Background(), zap.Logger(true), kobuilder, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rolledBack).To(BeFalse())
+
+		By("Building the spec again when it changes")
+		kobuilder.Generation = 4
+		Expect(checkoutToBuild(kobuilder)).To(Equal("2.0.0"))
+	})
+
+	It("should not rollback without a successful revision", func() {
+		kobuilder := &kov1alpha1.KoBuilder{
+			Spec: kov1alpha1.KoBuilderSpec{AutoRollback: true},
+			Status: kov1alpha1.KoBuilderStatus{
+				History: []kov1alpha1.KoBuilderRevision{{Revision: 1, Outcome: kov1alpha1.RevisionFailed}},
+			},
+		}
+		r := &KoBuilderReconciler{}
+		Expect(r.autoRollback(context.Background(), zap.Logger(true), kobuilder, 1)).To(BeFalse())
+	})
+
+	It("should rollback to the commit resolved by the operator when the builder reports none", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		Expect(kov1alpha1.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns", Generation: 2},
+			Spec:       kov1alpha1.KoBuilderSpec{Checkout: "2.0.0", AutoRollback: true},
+		}
+		recorder := record.NewFakeRecorder(10)
+		c := fake.NewFakeClientWithScheme(s, kobuilder.DeepCopy())
+		r := &KoBuilderReconciler{Client: c, APIReader: c, Recorder: recorder}
+		job := func(run string, checkout string, commit string) *batchv1.Job {
+			return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-ko-builder-" + run,
+				Namespace:   "my-ns",
+				Labels:      map[string]string{labelRun: run},
+				Annotations: map[string]string{annotationCheckout: checkout, annotationCommit: commit},
+			}}
+		}
+		// no pods of the jobs: the builder reports no commit
+		good := "0123456789abcdef0123456789abcdef01234567"
+		Expect(r.recordRun(context.Background(), kobuilder, job("1", "1.0.0", good), kov1alpha1.Deployed)).To(Succeed())
+		Expect(r.recordRun(context.Background(), kobuilder, job("2", "2.0.0", ""), kov1alpha1.ErrorDeploying)).To(Succeed())
+
+		rolledBack, err := r.autoRollback(context.Background(), zap.Logger(true), kobuilder, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rolledBack).To(BeTrue())
+		Expect(checkoutToBuild(kobuilder)).To(Equal(good))
+	})
+
+	It("should not rollback to a revision whose commit is unknown", func() {
+		kobuilder := &kov1alpha1.KoBuilder{
+			Spec: kov1alpha1.KoBuilderSpec{Checkout: "2.0.0", AutoRollback: true},
+			Status: kov1alpha1.KoBuilderStatus{
+				History: []kov1alpha1.KoBuilderRevision{
+					{Revision: 1, Checkout: "1.0.0", Outcome: kov1alpha1.RevisionSucceeded},
+					{Revision: 2, Checkout: "2.0.0", Outcome: kov1alpha1.RevisionFailed},
+				},
+			},
+		}
+		recorder := record.NewFakeRecorder(10)
+		r := &KoBuilderReconciler{Recorder: recorder}
+		Expect(r.autoRollback(context.Background(), zap.Logger(true), kobuilder, 2)).To(BeFalse())
+		Expect(kobuilder.Status.AutoRollback).To(BeNil())
+		Expect(recorder.Events).To(Receive(ContainSubstring("whose commit is unknown")))
+		Expect(checkoutToBuild(kobuilder)).To(Equal("2.0.0"))
+	})
+
+	It("should rollback to the commit of a successful revision", func() {
+		rollbackTo := func(revision int64) *kov1alpha1.KoBuilder {
+			return &kov1alpha1.KoBuilder{
+				Spec: kov1alpha1.KoBuilderSpec{Checkout: "3.0.0", RollbackTo: &revision},
+				Status: kov1alpha1.KoBuilderStatus{
+					History: []kov1alpha1.KoBuilderRevision{
+						{Revision: 1, Checkout: "1.0.0", Commit: "0123456", Outcome: kov1alpha1.RevisionSucceeded},
+						{Revision: 2, Checkout: "2.0.0", Outcome: kov1alpha1.RevisionSucceeded},
+						{Revision: 3, Checkout: "3.0.0", Commit: "789abcd", Outcome: kov1alpha1.RevisionFailed},
+					},
+				},
+			}
+		}
+		Expect(checkoutToBuild(rollbackTo(1))).To(Equal("0123456"))
+
+		By("Refusing a revision whose commit is unknown")
+		_, err := checkoutToBuild(rollbackTo(2))
+		Expect(err).To(MatchError("the commit of revision 2 is unknown"))
+
+		By("Refusing a failed revision")
+		_, err = checkoutToBuild(rollbackTo(3))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// defaultRunHistoryLimit is the number of runs whose Job and ConfigMap are kept when not specified
+	defaultRunHistoryLimit = 3
+
+	// labelKoBuilder is the label of the Jobs and ConfigMaps of a KoBuilder containing its name
+	labelKoBuilder = "ko.feloy.dev/kobuilder"
+	// labelRun is the label of the Jobs and ConfigMaps of a KoBuilder containing the number of their run
+	labelRun = "ko.feloy.dev/run"
+	// labelComponent is the label of the ConfigMaps of a KoBuilder containing their role, config or logs
+	labelComponent = "ko.feloy.dev/component"
+
+	componentConfig = "config"
+	componentLogs   = "logs"
+)
+
+func configMapName(kobuilder *kov1alpha1.KoBuilder, run int64) string {
+	return fmt.Sprintf("%s-config-%d", kobuilder.Name, run)
+}
+
+func jobName(kobuilder *kov1alpha1.KoBuilder, run int64) string {
+	return fmt.Sprintf("%s-job-%d", kobuilder.Name, run)
+}
+
+// runLabels returns the labels of the objects of the given component created for a run of kobuilder
+func runLabels(kobuilder *kov1alpha1.KoBuilder, run int64, component string) map[string]string {
+	labels := map[string]string{
+		labelKoBuilder: kobuilder.Name,
+		labelRun:       strconv.FormatInt(run, 10),
+	}
+	if component != "" {
+		labels[labelComponent] = component
+	}
+	return labels
+}
+
+// runOf returns the number of the run of the object with the given labels
+func runOf(labels map[string]string) int64 {
+	run, _ := strconv.ParseInt(labels[labelRun], 10, 64)
+	return run
+}
+
+// configHash returns a hash of the data of a ConfigMap, used to detect configuration changes
+func configHash(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%s\n", key, data[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// expectedConfigHash returns the hash of the configuration of a run of kobuilder building checkout
+func expectedConfigHash(kobuilder *kov1alpha1.KoBuilder, checkout string) string {
+	return configHash(createConfigMap(kobuilder, checkout, 0).Data)
+}
+
+// getRunJob returns the job of the last run of kobuilder, or nil if it does not exist
+func (r *KoBuilderReconciler) getRunJob(ctx context.Context, kobuilder *kov1alpha1.KoBuilder) (job *batchv1.Job, err error) {
+	if kobuilder.Status.Run == 0 {
+		return
+	}
+	job = new(batchv1.Job)
+	err = r.Get(ctx, types.NamespacedName{Name: jobName(kobuilder, kobuilder.Status.Run), Namespace: kobuilder.Namespace}, job)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return
+}
+
+// createRun creates the immutable ConfigMap and Job of a new run of kobuilder building checkout,
+// records the number of the run in the status of kobuilder,
+// and deletes the Jobs and ConfigMaps of the runs exceeding the run history limit
+func (r *KoBuilderReconciler) createRun(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, checkout string) (err error) {
+	run := kobuilder.Status.Run + 1
+	config := createConfigMap(kobuilder, checkout, run)
+	job := createJob(kobuilder, config.Name, checkout, r.BuilderImage, run)
+	job.Annotations[annotationConfigHash] = configHash(config.Data)
+	if commit := r.resolveCommit(ctx, log, kobuilder, checkout); commit != "" {
+		job.Annotations[annotationCommit] = commit
+	}
+
+	// the objects of a run whose status has not been recorded already exist
+	controllerutil.SetControllerReference(kobuilder, config, r.Scheme)
+	if err = r.Create(ctx, config); err != nil && !apierrors.IsAlreadyExists(err) {
+		log.Error(err, "unable to create configmap for kobuilder")
+		r.Recorder.Eventf(kobuilder, corev1.EventTypeWarning, eventFailedCreate, "Unable to create ConfigMap %s: %s", config.Name, err)
+		return
+	}
+	r.Recorder.Eventf(kobuilder, corev1.EventTypeNormal, eventConfigMapCreated, "Created ConfigMap %s to build %s", config.Name, checkout)
+
+	controllerutil.SetControllerReference(kobuilder, job, r.Scheme)
+	if err = r.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+		log.Error(err, "unable to create job for kobuilder")
+		r.Recorder.Eventf(kobuilder, corev1.EventTypeWarning, eventFailedCreate, "Unable to create Job %s: %s", job.Name, err)
+		return
+	}
+	r.Recorder.Eventf(kobuilder, corev1.EventTypeNormal, eventJobCreated, "Created Job %s to build %s", job.Name, checkout)
+	buildsStarted.WithLabelValues(kobuilder.Namespace, kobuilder.Name).Inc()
+
+	// the state is set to Pending for no other run to be created before the job is observed
+	kobuilder.Status.Run = run
+	kobuilder.Status.ConfigHash = configHash(config.Data)
+	if err = r.setState(ctx, log, kobuilder, kov1alpha1.Pending, reasonJobPending, "Waiting for the pod of the build to start"); err != nil {
+		return
+	}
+
+	_, err = r.pruneRuns(ctx, kobuilder, time.Now())
+	return
+}
+
+// resolveCommit returns the commit SHA checkout points to in the repository of kobuilder, for the revision of the run
+// to be rolled back to when the builder does not report its commit, or an empty string if it cannot be resolved
+func (r *KoBuilderReconciler) resolveCommit(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, checkout string) string {
+	if isCommitSHA(checkout) {
+		return checkout
+	}
+	credentials, err := r.getGitCredentials(ctx, kobuilder)
+	if err == nil {
+		var commit string
+		if commit, err = resolveCheckout(ctx, repositoryURL(kobuilder.Spec.Repository), checkout, credentials); err == nil {
+			return commit
+		}
+	}
+	log.Info(fmt.Sprintf("Unable to resolve the commit of %s: %s", checkout, err))
+	return ""
+}
+
+// pruneRuns deletes the Jobs, with their pods, and the config and manifests ConfigMaps of the runs of kobuilder
+// older than the run history limit, and the recorded failed Jobs, with their pods, kept for more than the failed job TTL.
+// It returns the duration after which the next failed Job has to be deleted, if any
+func (r *KoBuilderReconciler) pruneRuns(ctx context.Context, kobuilder *kov1alpha1.KoBuilder, now time.Time) (requeueAfter time.Duration, err error) {
+	oldest := kobuilder.Status.Run - int64(runHistoryLimit(kobuilder)) + 1
+	ttl, expires := failedJobTTL(kobuilder)
+
+	jobs := new(batchv1.JobList)
+	if err = r.List(ctx, jobs, client.InNamespace(kobuilder.Namespace), client.MatchingLabels{labelKoBuilder: kobuilder.Name}); err != nil {
+		return
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		message := fmt.Sprintf("Deleted Job %s of a previous run", job.Name)
+		if runOf(job.Labels) >= oldest {
+			completion := jobCompletionTime(job)
+			recorded := runOf(job.Labels) < kobuilder.Status.Run || runRecorded(kobuilder, job)
+			if !expires || !recorded || completion == nil || jobCondition(job, batchv1.JobFailed) == nil {
+				continue
+			}
+			if wait := completion.Add(ttl).Sub(now); wait > 0 {
+				if requeueAfter == 0 || wait < requeueAfter {
+					requeueAfter = wait
+				}
+				continue
+			}
+			message = fmt.Sprintf("Deleted failed Job %s after %s", job.Name, ttl)
+		}
+		if err = r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+			if err = client.IgnoreNotFound(err); err != nil {
+				return
+			}
+			continue
+		}
+		r.Recorder.Event(kobuilder, corev1.EventTypeNormal, eventJobDeleted, message)
+	}
+
+	configMaps := new(corev1.ConfigMapList)
+	if err = r.List(ctx, configMaps, client.InNamespace(kobuilder.Namespace), client.MatchingLabels{labelKoBuilder: kobuilder.Name}); err != nil {
+		return
+	}
+	for i := range configMaps.Items {
+		// the logs ConfigMaps have their own history limit,
+		// and the last applied manifests are kept to detect the drifts of the applied objects
+		labels := configMaps.Items[i].Labels
+		if labels[labelComponent] == componentLogs || runOf(labels) >= oldest ||
+			(labels[labelComponent] == componentManifests && runOf(labels) == kobuilder.Status.AppliedRun) {
+			continue
+		}
+		if err = client.IgnoreNotFound(r.Delete(ctx, &configMaps.Items[i])); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// runRecorded returns true if the run of job has been recorded in the status of kobuilder:
+// the job is marked as recorded once the status is persisted, the run being the last revision of the history meanwhile
+func runRecorded(kobuilder *kov1alpha1.KoBuilder, job *batchv1.Job) bool {
+	if job.Annotations[annotationRecorded] == "true" {
+		return true
+	}
+	history := kobuilder.Status.History
+	return len(history) > 0 && history[len(history)-1].Revision == runOf(job.Labels)
+}
+
+// failedJobTTL returns the duration a failed job of kobuilder is kept before being deleted,
+// and false if failed jobs are kept with the other runs
+func failedJobTTL(kobuilder *kov1alpha1.KoBuilder) (time.Duration, bool) {
+	if kobuilder.Spec.Logs == nil || kobuilder.Spec.Logs.FailedJobTTL == nil {
+		return 0, false
+	}
+	return kobuilder.Spec.Logs.FailedJobTTL.Duration, true
+}
+
+func runHistoryLimit(kobuilder *kov1alpha1.KoBuilder) int32 {
+	if kobuilder.Spec.RunHistoryLimit == nil {
+		return defaultRunHistoryLimit
+	}
+	return *kobuilder.Spec.RunHistoryLimit
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Runs of a KoBuilder", func() {
+
+	It("should hash the configuration independently of the order of its keys", func() {
+		Expect(configHash(map[string]string{"A": "1", "B": "2"})).To(Equal(configHash(map[string]string{"B": "2", "A": "1"})))
+		Expect(configHash(map[string]string{"A": "1", "B": "2"})).ToNot(Equal(configHash(map[string]string{"A": "1", "B": "3"})))
+		Expect(configHash(map[string]string{"A": "1=B"})).ToNot(Equal(configHash(map[string]string{"A": "1", "B": ""})))
+	})
+
+	It("should name and label the objects of a run", func() {
+		kobuilder := &kov1alpha1.KoBuilder{ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder"}}
+		Expect(jobName(kobuilder, 12)).To(Equal("my-ko-builder-job-12"))
+		Expect(configMapName(kobuilder, 12)).To(Equal("my-ko-builder-config-12"))
+		Expect(runOf(runLabels(kobuilder, 12, componentConfig))).To(BeEquivalentTo(12))
+		Expect(runLabels(kobuilder, 12, "")).ToNot(HaveKey(labelComponent))
+	})
+
+	It("should find the recorded runs", func() {
+		kobuilder := &kov1alpha1.KoBuilder{ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder"}}
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Labels: runLabels(kobuilder, 2, "")}}
+		Expect(runRecorded(kobuilder, job)).To(BeFalse())
+
+		// status persisted, job not marked yet
+		kobuilder.Status.History = []kov1alpha1.KoBuilderRevision{{Revision: 1}, {Revision: 2}}
+		Expect(runRecorded(kobuilder, job)).To(BeTrue())
+
+		kobuilder.Status.History = nil
+		job.Annotations = map[string]string{annotationRecorded: "true"}
+		Expect(runRecorded(kobuilder, job)).To(BeTrue())
+	})
+
+	It("should delete the objects of the runs exceeding the run history limit", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		Expect(kov1alpha1.AddToScheme(s)).To(Succeed())
+		limit := int32(2)
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns"},
+			Spec:       kov1alpha1.KoBuilderSpec{RunHistoryLimit: &limit},
+			Status:     kov1alpha1.KoBuilderStatus{Run: 3},
+		}
+		objects := []runtime.Object{kobuilder}
+		for run := int64(1); run <= 3; run++ {
+			objects = append(objects,
+				createJob(kobuilder, configMapName(kobuilder, run), "1.2.3", "builder", run),
+				createConfigMap(kobuilder, "1.2.3", run),
+			)
+		}
+		logs := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-ko-builder-logs-1",
+				Namespace: "my-ns",
+				Labels:    runLabels(kobuilder, 1, componentLogs),
+			},
+		}
+		objects = append(objects, logs)
+		r := &KoBuilderReconciler{
+			Client:   fake.NewFakeClientWithScheme(s, objects...),
+			Recorder: record.NewFakeRecorder(10),
+		}
+
+		_, err := r.pruneRuns(context.Background(), kobuilder, time.Now())
+		Expect(err).ToNot(HaveOccurred())
+
+		jobs := &batchv1.JobList{}
+		Expect(r.List(context.Background(), jobs, client.InNamespace("my-ns"))).To(Succeed())
+		var names []string
+		for _, job := range jobs.Items {
+			names = append(names, job.Name)
+		}
+		Expect(names).To(ConsistOf("my-ko-builder-job-2", "my-ko-builder-job-3"))
+
+		configMaps := &corev1.ConfigMapList{}
+		Expect(r.List(context.Background(), configMaps, client.InNamespace("my-ns"))).To(Succeed())
+		names = nil
+		for _, configMap := range configMaps.Items {
+			names = append(names, configMap.Name)
+		}
+		Expect(names).To(ConsistOf("my-ko-builder-config-2", "my-ko-builder-config-3", "my-ko-builder-logs-1"))
+	})
+
+	It("should delete the recorded failed jobs after the failed job TTL", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		now := time.Now()
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns"},
+			Spec: kov1alpha1.KoBuilderSpec{
+				Logs: &kov1alpha1.BuildLogsSpec{FailedJobTTL: &metav1.Duration{Duration: time.Hour}},
+			},
+			Status: kov1alpha1.KoBuilderStatus{Run: 3},
+		}
+		failedJob := func(run int64, age time.Duration) *batchv1.Job {
+			job := createJob(kobuilder, configMapName(kobuilder, run), "1.2.3", "builder", run)
+			job.Status.Conditions = []batchv1.JobCondition{{
+				Type:               batchv1.JobFailed,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(now.Add(-age)),
+			}}
+			return job
+		}
+		succeeded := createJob(kobuilder, configMapName(kobuilder, 1), "1.2.3", "builder", 1)
+		succeeded.Status.CompletionTime = &metav1.Time{Time: now.Add(-2 * time.Hour)}
+		// the failed job of the last run is not recorded yet
+		r := &KoBuilderReconciler{
+			Client:   fake.NewFakeClientWithScheme(s, succeeded, failedJob(2, 2*time.Hour), failedJob(3, 2*time.Hour)),
+			Recorder: record.NewFakeRecorder(10),
+		}
+
+		requeueAfter, err := r.pruneRuns(context.Background(), kobuilder, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requeueAfter).To(BeZero())
+		jobs := &batchv1.JobList{}
+		Expect(r.List(context.Background(), jobs, client.InNamespace("my-ns"))).To(Succeed())
+		var names []string
+		for _, job := range jobs.Items {
+			names = append(names, job.Name)
+		}
+		Expect(names).To(ConsistOf("my-ko-builder-job-1", "my-ko-builder-job-3"))
+
+		// the failed job of the last run is recorded
+		kobuilder.Status.History = []kov1alpha1.KoBuilderRevision{{Revision: 3}}
+		r.Client = fake.NewFakeClientWithScheme(s, failedJob(3, 20*time.Minute))
+		requeueAfter, err = r.pruneRuns(context.Background(), kobuilder, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requeueAfter).To(BeNumerically("~", 40*time.Minute, time.Second))
+	})
+})
@@ -6,11 +6,187 @@ import (
 
 	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
 	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func (r *KoBuilderReconciler) setState(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, state kov1alpha1.KoBuilderState) (err error) {
-	log.Info(fmt.Sprintf("Set State * %s *", state))
+// Reasons of the KoBuilder conditions
+const (
+	reasonConfigUpdated    = "ConfigUpdated"
+	reasonJobActive        = "JobActive"
+	reasonJobSucceeded     = "JobSucceeded"
+	reasonJobFailed        = "JobFailed"
+	reasonJobPending       = "JobPending"
+	reasonInvalidRollback  = "InvalidRollback"
+	reasonGitAuthFailed    = "GitAuthFailed"
+	reasonRebuildRequested = "RebuildRequested"
+	reasonRetrying         = "Retrying"
+	reasonTimedOut         = "BuildTimedOut"
+	reasonImagePullFailed  = "ImagePullFailed"
+	reasonPodPending       = "PodPending"
+	reasonBuildCanceled    = "BuildCanceled"
+	reasonSuspended        = "Suspended"
+	reasonResumed          = "Resumed"
+	reasonApplyFailed      = "ApplyFailed"
+	reasonRolloutPending   = "RolloutPending"
+	reasonUnhealthy        = "Unhealthy"
+	reasonAutoRollback     = "AutoRollback"
+	reasonRolledBack       = "RolledBack"
+	reasonDrifted          = "Drifted"
+	reasonDriftCorrected   = "DriftCorrected"
+	reasonNoDrift          = "NoDrift"
+)
+
+func (r *KoBuilderReconciler) setState(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, state kov1alpha1.KoBuilderState, reason string, message string) (err error) {
+	log.Info(fmt.Sprintf("Set State * %s * (%s)", state, reason))
 	kobuilder.Status.State = state
+	kobuilder.Status.ObservedGeneration = kobuilder.Generation
+	for _, condition := range conditionsForState(state, reason, message) {
+		setCondition(&kobuilder.Status, condition)
+	}
+	if state != kov1alpha1.Suspended && hasCondition(&kobuilder.Status, kov1alpha1.SuspendedCondition) {
+		setCondition(&kobuilder.Status, kov1alpha1.KoBuilderCondition{
+			Type: kov1alpha1.SuspendedCondition, Status: corev1.ConditionFalse, Reason: reason, Message: message,
+		})
+	}
 	err = r.Status().Update(ctx, kobuilder)
 	return
 }
+
+// recordRun records in the status of kobuilder the checkout, commit and times of the run of job.
+// When the job is terminated in the given state, the run is added to the history
+func (r *KoBuilderReconciler) recordRun(ctx context.Context, kobuilder *kov1alpha1.KoBuilder, job *batchv1.Job, state kov1alpha1.KoBuilderState) (err error) {
+	kobuilder.Status.Checkout = job.Annotations[annotationCheckout]
+	kobuilder.Status.StartTime = job.Status.StartTime
+	kobuilder.Status.CompletionTime = jobCompletionTime(job)
+	kobuilder.Status.Commit = ""
+
+	var outcome kov1alpha1.RevisionOutcome
+	switch state {
+	case kov1alpha1.Deployed:
+		outcome = kov1alpha1.RevisionSucceeded
+	case kov1alpha1.ErrorDeploying, kov1alpha1.Unhealthy:
+		outcome = kov1alpha1.RevisionFailed
+	default:
+		return
+	}
+
+	var pods []corev1.Pod
+	if pods, err = r.getJobPods(ctx, job); err != nil {
+		return
+	}
+	report := getBuildReport(pods)
+	if report.Commit == "" {
+		// the builder does not report its commit, the commit resolved when the run has been created is used
+		report.Commit = job.Annotations[annotationCommit]
+	}
+	kobuilder.Status.Commit = report.Commit
+	if outcome == kov1alpha1.RevisionSucceeded && report.Commit == "" {
+		r.Recorder.Eventf(kobuilder, corev1.EventTypeWarning, eventCommitUnknown,
+			"The commit of run %d is unknown, the revision cannot be rolled back to", runOf(job.Labels))
+	}
+
+	revision := kov1alpha1.KoBuilderRevision{
+		Revision: runOf(job.Labels),
+		Checkout: kobuilder.Status.Checkout,
+		Commit:   report.Commit,
+		Images:   report.Images,
+		Outcome:  outcome,
+		Time:     metav1.Now(),
+	}
+	if kobuilder.Status.CompletionTime != nil {
+		revision.Time = *kobuilder.Status.CompletionTime
+	}
+	appendRevision(&kobuilder.Status, revision, revisionHistoryLimit(kobuilder))
+	return
+}
+
+// conditionsForState returns the conditions to set on a KoBuilder entering the given state
+func conditionsForState(state kov1alpha1.KoBuilderState, reason string, message string) []kov1alpha1.KoBuilderCondition {
+	condition := func(t kov1alpha1.KoBuilderConditionType, status corev1.ConditionStatus) kov1alpha1.KoBuilderCondition {
+		return kov1alpha1.KoBuilderCondition{Type: t, Status: status, Reason: reason, Message: message}
+	}
+	switch state {
+	case kov1alpha1.Updated:
+		return []kov1alpha1.KoBuilderCondition{
+			condition(kov1alpha1.ReadyCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.BuildingCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.DeployedCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.DegradedCondition, corev1.ConditionFalse),
+		}
+	case kov1alpha1.Pending, kov1alpha1.Deploying:
+		return []kov1alpha1.KoBuilderCondition{
+			condition(kov1alpha1.ReadyCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.BuildingCondition, corev1.ConditionTrue),
+			condition(kov1alpha1.DeployedCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.DegradedCondition, corev1.ConditionFalse),
+		}
+	case kov1alpha1.Deployed:
+		return []kov1alpha1.KoBuilderCondition{
+			condition(kov1alpha1.ReadyCondition, corev1.ConditionTrue),
+			condition(kov1alpha1.BuildingCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.DeployedCondition, corev1.ConditionTrue),
+			condition(kov1alpha1.DegradedCondition, corev1.ConditionFalse),
+		}
+	case kov1alpha1.Suspended:
+		return []kov1alpha1.KoBuilderCondition{
+			condition(kov1alpha1.BuildingCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.SuspendedCondition, corev1.ConditionTrue),
+		}
+	case kov1alpha1.Unhealthy:
+		return []kov1alpha1.KoBuilderCondition{
+			condition(kov1alpha1.ReadyCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.BuildingCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.DeployedCondition, corev1.ConditionTrue),
+			condition(kov1alpha1.DegradedCondition, corev1.ConditionTrue),
+		}
+	case kov1alpha1.ErrorDeploying:
+		return []kov1alpha1.KoBuilderCondition{
+			condition(kov1alpha1.ReadyCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.BuildingCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.DeployedCondition, corev1.ConditionFalse),
+			condition(kov1alpha1.DegradedCondition, corev1.ConditionTrue),
+		}
+	default:
+		return []kov1alpha1.KoBuilderCondition{
+			condition(kov1alpha1.ReadyCondition, corev1.ConditionUnknown),
+			condition(kov1alpha1.BuildingCondition, corev1.ConditionUnknown),
+		}
+	}
+}
+
+// hasCondition returns true if status contains a condition of the given type
+func hasCondition(status *kov1alpha1.KoBuilderStatus, conditionType kov1alpha1.KoBuilderConditionType) bool {
+	return findCondition(status, conditionType) != nil
+}
+
+// findCondition returns the condition of the given type in status, or nil
+func findCondition(status *kov1alpha1.KoBuilderStatus, conditionType kov1alpha1.KoBuilderConditionType) *kov1alpha1.KoBuilderCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition adds or updates the condition of the same type in status.
+// The transition time is only changed when the status of the condition changes
+func setCondition(status *kov1alpha1.KoBuilderStatus, condition kov1alpha1.KoBuilderCondition) {
+	for i := range status.Conditions {
+		existing := &status.Conditions[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status != condition.Status {
+			existing.Status = condition.Status
+			existing.LastTransitionTime = metav1.Now()
+		}
+		existing.Reason = condition.Reason
+		existing.Message = condition.Message
+		return
+	}
+	condition.LastTransitionTime = metav1.Now()
+	status.Conditions = append(status.Conditions, condition)
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"context"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Conditions", func() {
+
+	It("should reset the Degraded condition when a new build starts", func() {
+		status := &kov1alpha1.KoBuilderStatus{}
+		for _, condition := range conditionsForState(kov1alpha1.ErrorDeploying, reasonJobFailed, "failed") {
+			setCondition(status, condition)
+		}
+		Expect(findCondition(status, kov1alpha1.DegradedCondition).Status).To(Equal(corev1.ConditionTrue))
+
+		for _, state := range []kov1alpha1.KoBuilderState{kov1alpha1.Updated, kov1alpha1.Pending, kov1alpha1.Deploying} {
+			for _, condition := range conditionsForState(state, "", "") {
+				setCondition(status, condition)
+			}
+			Expect(findCondition(status, kov1alpha1.DegradedCondition).Status).To(Equal(corev1.ConditionFalse))
+		}
+	})
+
+	It("should record the commit resolved by the operator when the builder reports none", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{}
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-ko-builder-1",
+			Namespace:   "my-ns",
+			Labels:      map[string]string{labelRun: "1"},
+			Annotations: map[string]string{annotationCheckout: "1.0.0", annotationCommit: "0123456789abcdef0123456789abcdef01234567"},
+		}}
+		recorder := record.NewFakeRecorder(10)
+		c := fake.NewFakeClientWithScheme(s)
+		r := &KoBuilderReconciler{Client: c, APIReader: c, Recorder: recorder}
+		Expect(r.recordRun(context.Background(), kobuilder, job, kov1alpha1.Deployed)).To(Succeed())
+		Expect(kobuilder.Status.Commit).To(Equal("0123456789abcdef0123456789abcdef01234567"))
+		Expect(kobuilder.Status.History[0].Commit).To(Equal("0123456789abcdef0123456789abcdef01234567"))
+		Expect(recorder.Events).ToNot(Receive())
+	})
+})
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultPendingTimeout is the maximum duration the pod of a build can stay pending when not specified
+	defaultPendingTimeout = 10 * time.Minute
+	// stuckCheckInterval is the interval between two checks of the pending pods of a build,
+	// the pods of the builds being not watched
+	stuckCheckInterval = 30 * time.Second
+)
+
+// imagePullFailures are the reasons of waiting containers whose image cannot be pulled
+var imagePullFailures = map[string]bool{
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+// stuckPod returns the reason and a message if one of pods cannot pull the image of one of its containers
+// or is pending since more than pendingTimeout, or an empty reason if no pod is stuck.
+// If some pods are pending but not stuck yet, it returns the duration after which they have to be checked again
+func stuckPod(pods []corev1.Pod, pendingTimeout time.Duration, now time.Time) (reason string, message string, recheckAfter time.Duration) {
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if waiting := status.State.Waiting; waiting != nil && imagePullFailures[waiting.Reason] {
+				message = fmt.Sprintf("Unable to pull image %s of container %s of pod %s: %s", status.Image, status.Name, pod.Name, waiting.Message)
+				return reasonImagePullFailed, message, 0
+			}
+		}
+		if pending := now.Sub(pod.CreationTimestamp.Time); pending > pendingTimeout {
+			message = fmt.Sprintf("Pod %s pending since %s", pod.Name, pending.Round(time.Second))
+			for _, condition := range pod.Status.Conditions {
+				if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+					message = fmt.Sprintf("%s: %s", message, condition.Message)
+				}
+			}
+			return reasonPodPending, message, 0
+		}
+		recheckAfter = stuckCheckInterval
+	}
+	return
+}
+
+func pendingTimeout(kobuilder *kov1alpha1.KoBuilder) time.Duration {
+	if kobuilder.Spec.PendingTimeout == nil {
+		return defaultPendingTimeout
+	}
+	return kobuilder.Spec.PendingTimeout.Duration
+}
+
+// deleteStuckJob deletes the job of a stuck build recorded as failed with its pods,
+// for a pod starting later not to deploy the manifests of the failed build
+func (r *KoBuilderReconciler) deleteStuckJob(ctx context.Context, kobuilder *kov1alpha1.KoBuilder, job *batchv1.Job) (err error) {
+	if err = r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	r.Recorder.Eventf(kobuilder, corev1.EventTypeNormal, eventJobDeleted, "Deleted Job %s of a stuck build", job.Name)
+	return
+}
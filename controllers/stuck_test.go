@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Stuck builds detection", func() {
+
+	now := time.Now()
+
+	pendingPod := func(age time.Duration) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "my-ko-builder-job-abcde",
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name:  "ko-builder",
+						Image: DefaultBuilderImage,
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	It("should detect images which cannot be pulled", func() {
+		pod := pendingPod(time.Minute)
+		pod.Status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{
+			Reason:  "ImagePullBackOff",
+			Message: "Back-off pulling image",
+		}
+		reason, message, _ := stuckPod([]corev1.Pod{pod}, defaultPendingTimeout, now)
+		Expect(reason).To(Equal(reasonImagePullFailed))
+		Expect(message).To(ContainSubstring(DefaultBuilderImage))
+	})
+
+	It("should detect pods pending for too long", func() {
+		pod := pendingPod(time.Hour)
+		pod.Status.Conditions = []corev1.PodCondition{
+			{
+				Type:    corev1.PodScheduled,
+				Status:  corev1.ConditionFalse,
+				Message: "0/3 nodes are available: 3 Insufficient cpu.",
+			},
+		}
+		reason, message, _ := stuckPod([]corev1.Pod{pod}, defaultPendingTimeout, now)
+		Expect(reason).To(Equal(reasonPodPending))
+		Expect(message).To(ContainSubstring("Insufficient cpu"))
+	})
+
+	It("should check pending pods again later", func() {
+		reason, _, recheckAfter := stuckPod([]corev1.Pod{pendingPod(time.Minute)}, defaultPendingTimeout, now)
+		Expect(reason).To(BeEmpty())
+		Expect(recheckAfter).To(Equal(stuckCheckInterval))
+
+		running := pendingPod(time.Hour)
+		running.Status.Phase = corev1.PodRunning
+		reason, _, recheckAfter = stuckPod([]corev1.Pod{running}, defaultPendingTimeout, now)
+		Expect(reason).To(BeEmpty())
+		Expect(recheckAfter).To(BeZero())
+	})
+
+	It("should delete the job of a stuck build", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns"}}
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder-job-1", Namespace: "my-ns"}}
+		r := &KoBuilderReconciler{Client: fake.NewFakeClientWithScheme(s, job), Recorder: record.NewFakeRecorder(10)}
+
+		Expect(r.deleteStuckJob(context.Background(), kobuilder, job)).To(Succeed())
+		err := r.Get(context.Background(), types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &batchv1.Job{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		// already deleted
+		Expect(r.deleteStuckJob(context.Background(), kobuilder, job)).To(Succeed())
+	})
+})
@@ -16,6 +16,7 @@ limitations under the License.
 package controllers
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -23,6 +24,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -50,9 +52,40 @@ func TestAPIs(t *testing.T) {
 		[]Reporter{envtest.NewlineReporter{}})
 }
 
+// controlPlaneAvailable returns true if envtest can start a control plane or use an existing cluster.
+// Otherwise the specs of the controller running against the control plane are skipped,
+// the other specs being run
+func controlPlaneAvailable() bool {
+	if os.Getenv("USE_EXISTING_CLUSTER") == "true" {
+		return true
+	}
+	apiServer := os.Getenv("TEST_ASSET_KUBE_APISERVER")
+	if apiServer == "" {
+		assets := os.Getenv("KUBEBUILDER_ASSETS")
+		if assets == "" {
+			assets = "/usr/local/kubebuilder/bin"
+		}
+		apiServer = filepath.Join(assets, "kube-apiserver")
+	}
+	_, err := os.Stat(apiServer)
+	return err == nil
+}
+
+// skipWithoutControlPlane skips the current spec if the test environment has not been started
+func skipWithoutControlPlane() {
+	if testEnv == nil {
+		Skip("no control plane available, set KUBEBUILDER_ASSETS to run the specs of the controller")
+	}
+}
+
 var _ = BeforeSuite(func(done Done) {
 	logf.SetLogger(zap.LoggerTo(GinkgoWriter, true))
 
+	if !controlPlaneAvailable() {
+		close(done)
+		return
+	}
+
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
 		CRDDirectoryPaths: []string{filepath.Join("..", "config", "crd", "bases")},
@@ -72,9 +105,14 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 
 	err = (&KoBuilderReconciler{
-		Client: k8sManager.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("KoBuilder"),
-		Scheme: k8sManager.GetScheme(),
+		Client:       k8sManager.GetClient(),
+		Log:          ctrl.Log.WithName("controllers").WithName("KoBuilder"),
+		Scheme:       k8sManager.GetScheme(),
+		RESTMapper:   k8sManager.GetRESTMapper(),
+		APIReader:    k8sManager.GetAPIReader(),
+		BuilderImage: DefaultBuilderImage,
+		PodLogs:      kubernetes.NewForConfigOrDie(cfg).CoreV1(),
+		Recorder:     k8sManager.GetEventRecorderFor("ko-operator"),
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -93,6 +131,9 @@ var _ = BeforeSuite(func(done Done) {
 }, 60)
 
 var _ = AfterSuite(func() {
+	if testEnv == nil {
+		return
+	}
 	By("tearing down the test environment")
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
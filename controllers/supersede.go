@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// jobOutdated returns true if the inputs of the build of job have changed: if job has been created
+// for another checkout than the checkout to build or for another configuration than the configuration of kobuilder.
+// The changes of the other fields of the spec do not outdate the job
+func jobOutdated(job *batchv1.Job, kobuilder *kov1alpha1.KoBuilder, checkout string) bool {
+	if job.Annotations[annotationCheckout] != checkout {
+		return true
+	}
+	hash, ok := job.Annotations[annotationConfigHash]
+	if !ok {
+		// job created before its configuration hash was recorded
+		return job.Annotations[annotationGeneration] != strconv.FormatInt(kobuilder.Generation, 10)
+	}
+	return hash != expectedConfigHash(kobuilder, checkout)
+}
+
+func supersedePolicy(kobuilder *kov1alpha1.KoBuilder) kov1alpha1.SupersedePolicy {
+	if kobuilder.Spec.SupersedePolicy == "" {
+		return kov1alpha1.CancelSupersededBuild
+	}
+	return kobuilder.Spec.SupersedePolicy
+}
+
+// cancelJob deletes the running job built for previous build inputs of kobuilder, or of a suspended kobuilder, with its pods,
+// and sets kobuilder as Updated for a new job to be created
+func (r *KoBuilderReconciler) cancelJob(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder, job *batchv1.Job) (err error) {
+	if err = r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	message := fmt.Sprintf("Build of %s canceled by a spec change, a new build is pending", job.Annotations[annotationCheckout])
+	if kobuilder.Spec.Suspend {
+		message = fmt.Sprintf("Build of %s canceled by the suspension", job.Annotations[annotationCheckout])
+	}
+	r.Recorder.Event(kobuilder, corev1.EventTypeNormal, reasonBuildCanceled, message)
+	kobuilder.Status.Attempts = 0
+	kobuilder.Status.NextRetryTime = nil
+	return r.setState(ctx, log, kobuilder, kov1alpha1.Updated, reasonBuildCanceled, message)
+}
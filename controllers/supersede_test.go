@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Superseded builds", func() {
+
+	kobuilder := func() *kov1alpha1.KoBuilder {
+		return &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns", Generation: 1},
+			Spec: kov1alpha1.KoBuilderSpec{
+				Registry:   "gcr.io/my-project",
+				Repository: "github.com/my-org/my-repo",
+				Checkout:   "1.0.0",
+				ConfigPath: "config",
+			},
+		}
+	}
+	runJob := func(kobuilder *kov1alpha1.KoBuilder, checkout string) *batchv1.Job {
+		job := createJob(kobuilder, configMapName(kobuilder, 1), checkout, DefaultBuilderImage, 1)
+		job.Annotations[annotationConfigHash] = expectedConfigHash(kobuilder, checkout)
+		return job
+	}
+
+	It("should keep the job running when a field which is not a build input changes", func() {
+		current := kobuilder()
+		job := runJob(current, "1.0.0")
+
+		current.Generation = 2
+		current.Spec.Timeout = &metav1.Duration{Duration: time.Hour}
+		current.Spec.RetryPolicy = &kov1alpha1.RetryPolicy{MaxRetries: 3}
+		Expect(jobOutdated(job, current, "1.0.0")).To(BeFalse())
+	})
+
+	It("should outdate the job when the build inputs change", func() {
+		current := kobuilder()
+		job := runJob(current, "1.0.0")
+		Expect(jobOutdated(job, current, "1.0.1")).To(BeTrue())
+
+		current.Spec.ConfigPath = "other-config"
+		Expect(jobOutdated(job, current, "1.0.0")).To(BeTrue())
+	})
+
+	It("should compare the generations for the jobs without configuration hash", func() {
+		current := kobuilder()
+		job := createJob(current, configMapName(current, 1), "1.0.0", DefaultBuilderImage, 1)
+		Expect(jobOutdated(job, current, "1.0.0")).To(BeFalse())
+		current.Generation = 2
+		Expect(jobOutdated(job, current, "1.0.0")).To(BeTrue())
+	})
+})
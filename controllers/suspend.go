@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+)
+
+// resumedState returns the state of a resumed KoBuilder, with the reason and message of its conditions:
+// the state of its last run if it has been recorded, or Updated for a new run to be created
+func resumedState(status *kov1alpha1.KoBuilderStatus) (state kov1alpha1.KoBuilderState, reason string, message string) {
+	revision := findRevision(status.History, status.Run)
+	switch {
+	case revision == nil:
+		return kov1alpha1.Updated, reasonResumed, "Builds resumed, a new build is pending"
+	case revision.Outcome == kov1alpha1.RevisionSucceeded:
+		return kov1alpha1.Deployed, reasonResumed, "Builds resumed, the last build succeeded"
+	default:
+		return kov1alpha1.ErrorDeploying, reasonResumed, "Builds resumed, the last build failed"
+	}
+}
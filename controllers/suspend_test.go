@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resume of a suspended KoBuilder", func() {
+
+	It("should restore the state of the last recorded run", func() {
+		status := &kov1alpha1.KoBuilderStatus{
+			Run: 2,
+			History: []kov1alpha1.KoBuilderRevision{
+				{Revision: 1, Outcome: kov1alpha1.RevisionSucceeded},
+				{Revision: 2, Outcome: kov1alpha1.RevisionFailed},
+			},
+		}
+		state, reason, _ := resumedState(status)
+		Expect(state).To(Equal(kov1alpha1.ErrorDeploying))
+		Expect(reason).To(Equal(reasonResumed))
+
+		status.History[1].Outcome = kov1alpha1.RevisionSucceeded
+		state, _, _ = resumedState(status)
+		Expect(state).To(Equal(kov1alpha1.Deployed))
+	})
+
+	It("should start a new build if the last run has not been recorded", func() {
+		state, _, _ := resumedState(&kov1alpha1.KoBuilderStatus{})
+		Expect(state).To(Equal(kov1alpha1.Updated))
+
+		// the last run has been canceled by the suspension
+		state, _, _ = resumedState(&kov1alpha1.KoBuilderStatus{
+			Run:     2,
+			History: []kov1alpha1.KoBuilderRevision{{Revision: 1, Outcome: kov1alpha1.RevisionSucceeded}},
+		})
+		Expect(state).To(Equal(kov1alpha1.Updated))
+	})
+})
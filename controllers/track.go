@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// defaultTrackInterval is the interval between two resolutions of the head of a tracked branch when not specified
+const defaultTrackInterval = 5 * time.Minute
+
+// trackBranch resolves the head of the branch tracked by kobuilder, if the interval has elapsed
+// since the last resolution, and records it in the status.
+// It returns the duration after which the branch has to be resolved again
+func (r *KoBuilderReconciler) trackBranch(ctx context.Context, log logr.Logger, kobuilder *kov1alpha1.KoBuilder) (requeueAfter time.Duration, err error) {
+	track := kobuilder.Spec.Track
+	if track == nil {
+		return
+	}
+	interval := defaultTrackInterval
+	if track.Interval != nil {
+		interval = track.Interval.Duration
+	}
+
+	status := &kobuilder.Status
+	if status.TrackedBranch == track.Branch && status.LastTrackTime != nil {
+		if elapsed := time.Since(status.LastTrackTime.Time); elapsed < interval {
+			requeueAfter = interval - elapsed
+			return
+		}
+	}
+
+	var credentials *gitCredentials
+	if credentials, err = r.getGitCredentials(ctx, kobuilder); err != nil {
+		return
+	}
+	var commit string
+	if commit, err = resolveBranch(ctx, repositoryURL(kobuilder.Spec.Repository), track.Branch, credentials); err != nil {
+		return
+	}
+	if commit != status.TrackedCommit {
+		log.Info(fmt.Sprintf("Head of branch %s is now %s", track.Branch, commit))
+	}
+
+	status.TrackedBranch = track.Branch
+	status.TrackedCommit = commit
+	now := metav1.Now()
+	status.LastTrackTime = &now
+	err = r.Status().Update(ctx, kobuilder)
+	requeueAfter = interval
+	return
+}
+
+// repositoryURL returns the URL of the repository, using https if no scheme is specified
+func repositoryURL(repository string) string {
+	if strings.Contains(repository, "://") {
+		return repository
+	}
+	return "https://" + repository
+}
+
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get
+
+// getGitCredentials returns the credentials to access the repository of kobuilder over https, if any
+func (r *KoBuilderReconciler) getGitCredentials(ctx context.Context, kobuilder *kov1alpha1.KoBuilder) (credentials *gitCredentials, err error) {
+	gitAuth := kobuilder.Spec.GitAuth
+	if gitAuth == nil {
+		return
+	}
+	if gitAuth.Type != kov1alpha1.TokenGitAuth {
+		err = fmt.Errorf("git credentials of type %s are not supported to resolve branches", gitAuth.Type)
+		return
+	}
+	// the secret is read from the API server, for the secrets of the cluster not to be cached
+	secret := new(corev1.Secret)
+	if err = r.APIReader.Get(ctx, types.NamespacedName{Name: gitAuth.SecretName, Namespace: kobuilder.Namespace}, secret); err != nil {
+		return
+	}
+	credentials = &gitCredentials{
+		Username: "oauth2",
+		Password: string(secret.Data["token"]),
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"context"
+
+	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var _ = Describe("Branch tracking", func() {
+
+	It("should build the last resolved head when the branch cannot be resolved", func() {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		Expect(kov1alpha1.AddToScheme(s)).To(Succeed())
+		kobuilder := &kov1alpha1.KoBuilder{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-ko-builder", Namespace: "my-ns"},
+			Spec: kov1alpha1.KoBuilderSpec{
+				Registry:   "gcr.io/my-project",
+				Repository: "github.com/my-org/my-repo",
+				Checkout:   "main",
+				ConfigPath: "config",
+				Track:      &kov1alpha1.TrackSpec{Branch: "main"},
+				// ssh credentials cannot be used to resolve the branch
+				GitAuth: &kov1alpha1.GitAuth{Type: kov1alpha1.SSHGitAuth, SecretName: "git-ssh"},
+			},
+			Status: kov1alpha1.KoBuilderStatus{TrackedBranch: "main", TrackedCommit: "0123456"},
+		}
+		c := fake.NewFakeClientWithScheme(s, kobuilder)
+		recorder := record.NewFakeRecorder(10)
+		r := &KoBuilderReconciler{Client: c, APIReader: c, Log: zap.Logger(true), Scheme: s, Recorder: recorder, BuilderImage: DefaultBuilderImage}
+
+		key := types.NamespacedName{Name: "my-ko-builder", Namespace: "my-ns"}
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).To(MatchError(ContainSubstring("not supported to resolve branches")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning " + eventTrackFailed)))
+
+		jobs := new(batchv1.JobList)
+		Expect(c.List(context.Background(), jobs, client.InNamespace("my-ns"))).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+		Expect(jobs.Items[0].Annotations[annotationCheckout]).To(Equal("0123456"))
+	})
+})
@@ -22,10 +22,12 @@ import (
 	kov1alpha1 "github.com/feloy/ko-operator/api/v1alpha1"
 	"github.com/feloy/ko-operator/controllers"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -44,9 +46,22 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var builderImage string
+	var pushWebhookAddr string
+	var defaults kov1alpha1.KoBuilderDefaults
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&pushWebhookAddr, "push-webhook-addr", ":8082", "The address the git push webhooks endpoint binds to, 0 to disable it.")
+	flag.StringVar(&builderImage, "builder-image", controllers.DefaultBuilderImage,
+		"The ko-builder image used when not specified by the KoBuilder. "+
+			"Its container must report the built commit and images as commit=<sha> and image=<ref> lines of its termination message.")
+	flag.StringVar(&defaults.Registry, "default-registry", "",
+		"The default registry of KoBuilders, when not defined by the "+kov1alpha1.DefaultRegistryAnnotation+" annotation of their namespace.")
+	flag.StringVar(&defaults.ServiceAccount, "default-service-account", "",
+		"The default service account of KoBuilders, when not defined by the "+kov1alpha1.DefaultServiceAccountAnnotation+" annotation of their namespace.")
+	flag.StringVar(&defaults.Checkout, "default-checkout", "HEAD", "The default checkout of KoBuilders, HEAD being the default branch of the repository.")
+	flag.StringVar(&defaults.ConfigPath, "default-config-path", "/config", "The default config path of KoBuilders.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
@@ -65,13 +80,37 @@ func main() {
 	}
 
 	if err = (&controllers.KoBuilderReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("KoBuilder"),
-		Scheme: mgr.GetScheme(),
+		Client:       mgr.GetClient(),
+		Log:          ctrl.Log.WithName("controllers").WithName("KoBuilder"),
+		Scheme:       mgr.GetScheme(),
+		RESTMapper:   mgr.GetRESTMapper(),
+		APIReader:    mgr.GetAPIReader(),
+		BuilderImage: builderImage,
+		PodLogs:      kubernetes.NewForConfigOrDie(mgr.GetConfig()).CoreV1(),
+		Recorder:     mgr.GetEventRecorderFor("ko-operator"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "KoBuilder")
 		os.Exit(1)
 	}
+	metrics.Registry.MustRegister(&controllers.KoBuilderCollector{Reader: mgr.GetClient()})
+	if pushWebhookAddr != "0" {
+		if err = mgr.Add(&controllers.PushReceiver{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Log:       ctrl.Log.WithName("push"),
+			Addr:      pushWebhookAddr,
+		}); err != nil {
+			setupLog.Error(err, "unable to create push webhooks receiver")
+			os.Exit(1)
+		}
+	}
+
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&kov1alpha1.KoBuilder{}).SetupWebhookWithManager(mgr, defaults); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "KoBuilder")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")